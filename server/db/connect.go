@@ -1,20 +1,62 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"log"
 	"os"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	"github.com/yura4ka/crickter/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 )
 
 var Client *sql.DB
 
 func Connect() {
 	var err error
-	Client, err = sql.Open("postgres", os.Getenv("DB_CONNECTION"))
+	Client, err = otelsql.Open("postgres", os.Getenv("DB_CONNECTION"),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableQuery:         true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+		otelsql.WithAttributesGetter(queryAttributes),
+	)
 
 	if err != nil {
 		log.Fatalf("failed opening connection to postgres: %v", err)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = Client.PingContext(ctx); err != nil {
+		log.Fatalf("failed connecting to postgres: %v", err)
+	}
+}
+
+// queryAttributes replaces the raw statement otelsql would record with
+// a sanitized one.
+func queryAttributes(ctx context.Context, method otelsql.Method, query string, args []driver.NamedValue) []attribute.KeyValue {
+	if query == "" {
+		return nil
+	}
+	return []attribute.KeyValue{semconv.DBStatement(tracing.SanitizeQuery(query))}
+}
+
+func Ping(ctx context.Context) error {
+	return Client.PingContext(ctx)
+}
+
+func Close() error {
+	if Client == nil {
+		return nil
+	}
+	return Client.Close()
 }
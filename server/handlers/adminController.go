@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/logging"
+)
+
+func GetLogLevel(c *fiber.Ctx) error {
+	return sendJSON(c, fiber.Map{
+		"level": logging.GetLevel(),
+	})
+}
+
+func SetLogLevel(c *fiber.Ctx) error {
+	type Input struct {
+		Level string `json:"level"`
+	}
+	input := new(Input)
+	if err := c.BodyParser(input); err != nil {
+		return c.SendStatus(400)
+	}
+
+	if err := logging.SetLevel(input.Level); err != nil {
+		return c.SendStatus(400)
+	}
+
+	logging.FromContext(c.UserContext()).Info("log level changed", "level", logging.GetLevel())
+	return sendJSON(c, fiber.Map{
+		"level": logging.GetLevel(),
+	})
+}
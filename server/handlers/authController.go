@@ -3,9 +3,10 @@ package handlers
 import (
 	"database/sql"
 	"errors"
-	"log"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/metrics"
 	"github.com/yura4ka/crickter/services"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -14,18 +15,18 @@ func Register(c *fiber.Ctx) error {
 	input := new(services.NewUser)
 
 	if err := c.BodyParser(input); err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	id, err := services.CreateUser(input)
+	id, err := services.CreateUser(c.UserContext(), input)
 
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"id": id,
 	})
 }
@@ -41,28 +42,32 @@ func Login(c *fiber.Ctx) error {
 		return c.SendStatus(400)
 	}
 
-	user, err := services.GetUserByEmail(input.Email)
+	user, err := services.GetUserByEmail(c.UserContext(), input.Email)
 	if err != nil {
-		log.Print(err)
+		metrics.Logins.WithLabelValues("failed").Inc()
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		log.Print(err)
+		metrics.Logins.WithLabelValues("failed").Inc()
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
+	metrics.Logins.WithLabelValues("succeeded").Inc()
+
 	access, _ := services.CreateAccessToken(services.TokenPayload{Id: user.ID})
 	refresh, _ := services.CreateRefreshToken(services.TokenPayload{Id: user.ID})
 	if access == "" || refresh == "" {
-		log.Print("Error creating token")
+		logging.FromContext(c.UserContext()).Error("error creating token")
 		return c.SendStatus(400)
 	}
 
 	ucare, expire := services.CreateUcareToken(services.GetAccessMaxAge())
 
 	c.Cookie(services.CreateRefreshCookie(refresh))
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"token":      access,
 		"ucareToken": ucare,
 		"expire":     expire,
@@ -80,13 +85,13 @@ func Refresh(c *fiber.Ctx) error {
 	refresh := c.Cookies("refresh_token")
 	payload, err := services.VerifyRefreshToken(refresh)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	user, err := services.GetUserById(payload.Id)
+	user, err := services.GetUserById(c.UserContext(), payload.Id)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 	}
 
 	if err != nil || user.IsDeleted {
@@ -97,14 +102,14 @@ func Refresh(c *fiber.Ctx) error {
 	newAccess, _ := services.CreateAccessToken(services.TokenPayload{Id: payload.Id})
 	newRefresh, _ := services.CreateRefreshToken(services.TokenPayload{Id: payload.Id})
 	if newAccess == "" || newRefresh == "" {
-		log.Print("Error creating token")
+		logging.FromContext(c.UserContext()).Error("error creating token")
 		return c.SendStatus(400)
 	}
 
 	ucare, expire := services.CreateUcareToken(services.GetAccessMaxAge())
 
 	c.Cookie(services.CreateRefreshCookie(newRefresh))
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"token":      newAccess,
 		"ucareToken": ucare,
 		"expire":     expire,
@@ -128,7 +133,7 @@ func CheckEmail(c *fiber.Ctx) error {
 		return c.SendStatus(400)
 	}
 
-	_, err := services.GetUserByEmail(input.Email)
+	_, err := services.GetUserByEmail(c.UserContext(), input.Email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return c.SendStatus(200)
@@ -155,7 +160,7 @@ func CheckUsername(c *fiber.Ctx) error {
 	}
 
 	userId, _ := c.Locals("userId").(string)
-	u, err := services.GetUserByUsername(input.Username)
+	u, err := services.GetUserByUsername(c.UserContext(), input.Username)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
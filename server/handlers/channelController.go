@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+func GetChannelPreview(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	handle := c.Params("handle")
+
+	preview, err := services.GetChannelPreview(c.UserContext(), handle, userId)
+	if err != nil {
+		logError(c, err)
+		if errors.Is(err, services.ErrChannelNotFound) {
+			return c.SendStatus(404)
+		}
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, preview)
+}
+
+func JoinChannel(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	handle := c.Params("handle")
+
+	convId, err := services.JoinChannel(c.UserContext(), handle, userId)
+	if err != nil {
+		logError(c, err)
+		if errors.Is(err, services.ErrChannelNotFound) {
+			return c.SendStatus(404)
+		}
+		if errors.Is(err, services.ErrUserKicked) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "user has been kicked from the conversation",
+			})
+		}
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, convId)
+}
@@ -1,8 +1,6 @@
 package handlers
 
 import (
-	"log"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/yura4ka/crickter/services"
 )
@@ -12,21 +10,21 @@ func GetComments(c *fiber.Ctx) error {
 	page := c.QueryInt("page", 1)
 	postId := c.Query("postId")
 
-	comments, err := services.GetPosts(&services.QueryParams{
+	comments, err := services.GetPosts(c.UserContext(), &services.QueryParams{
 		RequestUserId: userId, CommentsToId: postId, Page: page, OrderBy: services.SortPopular,
 	})
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	total, hasMore, err := services.CountComments(postId, page)
+	total, hasMore, err := services.CountComments(c.UserContext(), postId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"comments": comments,
 		"total":    total,
 		"hasMore":  hasMore,
@@ -39,27 +37,27 @@ func GetResponses(c *fiber.Ctx) error {
 	page := c.QueryInt("page", 1)
 	postId := c.Query("postId")
 
-	comments, err := services.GetPosts(&services.QueryParams{
+	comments, err := services.GetPosts(c.UserContext(), &services.QueryParams{
 		RequestUserId: userId, ResponseToId: commentId, Page: page, OrderBy: services.SortOld,
 	})
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	total, hasMore, err := services.CountResponses(commentId, page)
+	total, hasMore, err := services.CountResponses(c.UserContext(), commentId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	totalComments, _, err := services.CountComments(postId, page)
+	totalComments, _, err := services.CountComments(c.UserContext(), postId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"comments":      comments,
 		"total":         total,
 		"hasMore":       hasMore,
@@ -1,9 +1,6 @@
 package handlers
 
 import (
-	"errors"
-	"log"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/yura4ka/crickter/services"
 )
@@ -11,29 +8,67 @@ import (
 func CreateConversation(c *fiber.Ctx) error {
 	input := new(services.CreateConversationRequest)
 	if err := c.BodyParser(input); err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 	userId := c.Locals("userId").(string)
 
-	id, err := services.CreateConversation(userId, input)
+	id, err := services.CreateConversation(c.UserContext(), userId, input)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(id)
+	return sendJSON(c, id)
 }
 
 func GetConversations(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(string)
-	conv, err := services.GetConversations(userId)
+	archived := c.QueryBool("archived")
+	conv, err := services.GetConversations(c.UserContext(), userId, archived)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, conv)
+}
+
+func GetMessageRequests(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	conv, err := services.GetMessageRequests(c.UserContext(), userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(conv)
+	return sendJSON(c, conv)
+}
+
+func AcceptMessageRequest(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	err := services.AcceptMessageRequest(c.UserContext(), convId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func DeclineMessageRequest(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	err := services.DeclineMessageRequest(c.UserContext(), convId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
 }
 
 func AddUsersToConversation(c *fiber.Ctx) error {
@@ -42,16 +77,16 @@ func AddUsersToConversation(c *fiber.Ctx) error {
 	}
 	input := new(Input)
 	if err := c.BodyParser(input); err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
 	userId := c.Locals("userId").(string)
 	convId := c.Params("id")
 
-	err := services.AddUsersToConversation(userId, convId, input.Users)
+	err := services.AddUsersToConversation(c.UserContext(), userId, convId, input.Users)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
@@ -64,73 +99,104 @@ func KickUser(c *fiber.Ctx) error {
 	}
 	input := new(Input)
 	if err := c.BodyParser(input); err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
 	userId := c.Locals("userId").(string)
 	convId := c.Params("id")
 
-	err := services.KickUser(convId, input.UserId, userId)
+	err := services.KickUser(c.UserContext(), convId, input.UserId, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
 	return c.SendStatus(200)
 }
 
-func LeaveConversation(c *fiber.Ctx) error {
+func PromoteUser(c *fiber.Ctx) error {
+	return setParticipantRole(c, services.RoleAdmin)
+}
+
+func DemoteUser(c *fiber.Ctx) error {
+	return setParticipantRole(c, services.RoleMember)
+}
+
+func setParticipantRole(c *fiber.Ctx, role string) error {
+	type Input struct {
+		UserId string `json:"userId"`
+	}
+	input := new(Input)
+	if err := c.BodyParser(input); err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
 	userId := c.Locals("userId").(string)
 	convId := c.Params("id")
 
-	err := services.LeaveConversation(convId, userId)
+	err := services.SetParticipantRole(c.UserContext(), convId, userId, input.UserId, role)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
 	return c.SendStatus(200)
 }
 
-func GetConversationInfo(c *fiber.Ctx) error {
+func LeaveConversation(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(string)
 	convId := c.Params("id")
-	info, err := services.GetConversationInfo(convId, userId)
+
+	err := services.LeaveConversation(c.UserContext(), convId, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
-	return c.JSON(info)
+
+	return c.SendStatus(200)
 }
 
-func JoinConversation(c *fiber.Ctx) error {
+func GetConversationInfo(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(string)
 	convId := c.Params("id")
-	err := services.JoinConversation(convId, userId)
+	info, err := services.GetConversationInfo(c.UserContext(), convId, userId)
 	if err != nil {
-		log.Print(err)
-		if errors.Is(err, services.ErrUserKicked) {
-			return c.Status(400).JSON(fiber.Map{
-				"error": "user has been kicked from the conversation",
-			})
-		}
+		logError(c, err)
 		return c.SendStatus(400)
 	}
-	return c.SendStatus(200)
+	return sendJSON(c, info)
 }
 
 func GetMessages(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	convId := c.Params("id")
 
-	m, err := services.GetMessages(convId, userId)
+	// paging is opt-in, without a cursor the whole history is returned
+	before, after := c.Query("before"), c.Query("after")
+	if before != "" || after != "" {
+		cursor := before
+		if after != "" {
+			cursor = after
+		}
+
+		page, err := services.GetMessagesPage(c.UserContext(), convId, userId, cursor, after != "", c.QueryInt("limit", -1))
+		if err != nil {
+			logError(c, err)
+			return c.SendStatus(400)
+		}
+
+		return sendJSON(c, page)
+	}
+
+	m, err := services.GetMessages(c.UserContext(), convId, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(m)
+	return sendJSON(c, m)
 }
 
 func EditConversation(c *fiber.Ctx) error {
@@ -141,9 +207,9 @@ func EditConversation(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	convId := c.Params("id")
 
-	err := services.EditConversation(input, convId, userId)
+	err := services.EditConversation(c.UserContext(), input, convId, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
@@ -154,11 +220,94 @@ func DeleteConversation(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	convId := c.Params("id")
 
-	err := services.DeleteConversation(convId, userId)
+	err := services.DeleteConversation(c.UserContext(), convId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func ReadConversation(c *fiber.Ctx) error {
+	type Input struct {
+		MessageId string `json:"messageId"`
+	}
+	input := new(Input)
+	if err := c.BodyParser(input); err != nil && len(c.Body()) != 0 {
+		return c.SendStatus(400)
+	}
+	userId, _ := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	err := services.ReadConversation(c.UserContext(), convId, userId, input.MessageId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
 	return c.SendStatus(200)
 }
+
+func PinMessage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+	messageId := c.Params("messageId")
+
+	err := services.PinMessage(c.UserContext(), convId, messageId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func UnpinMessage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+	messageId := c.Params("messageId")
+
+	err := services.UnpinMessage(c.UserContext(), convId, messageId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func UpdateConversationSettings(c *fiber.Ctx) error {
+	input := new(services.ConversationSettingsRequest)
+	if err := c.BodyParser(input); err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	err := services.UpdateConversationSettings(c.UserContext(), convId, userId, input)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func SearchConversation(c *fiber.Ctx) error {
+	q := c.Query("q")
+	if q == "" {
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	result, err := services.SearchConversation(c.UserContext(), convId, userId, q, c.Query("cursor"))
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, result)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+func CreateConversationExport(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	export, err := services.CreateConversationExport(c.UserContext(), convId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, export)
+}
+
+func GetConversationExport(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	exportId := c.Params("id")
+
+	export, err := services.GetConversationExport(c.UserContext(), exportId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, export)
+}
+
+func DownloadExport(c *fiber.Ctx) error {
+	token := c.Params("token")
+	format := c.Query("format", "json")
+
+	data, err := services.GetExportFile(c.UserContext(), token, format)
+	if err != nil {
+		logError(c, err)
+		if errors.Is(err, services.ErrExportNotFound) {
+			return c.SendStatus(404)
+		}
+		return c.SendStatus(400)
+	}
+
+	c.Attachment("conversation." + format)
+	return c.SendString(data)
+}
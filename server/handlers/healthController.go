@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+func Healthz(c *fiber.Ctx) error {
+	return sendJSON(c, fiber.Map{
+		"status": "ok",
+	})
+}
+
+func Readyz(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 2*time.Second)
+	defer cancel()
+
+	info, err := services.GetReadiness(ctx)
+	if err != nil {
+		logError(c, err)
+		return c.Status(503).JSON(fiber.Map{
+			"status":           "unavailable",
+			"database":         info.Database,
+			"migrationVersion": info.MigrationVersion,
+		})
+	}
+
+	return sendJSON(c, fiber.Map{
+		"status":           "ok",
+		"database":         info.Database,
+		"migrationVersion": info.MigrationVersion,
+	})
+}
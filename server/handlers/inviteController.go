@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+func CreateInvite(c *fiber.Ctx) error {
+	input := new(services.CreateInviteRequest)
+	if err := c.BodyParser(input); err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	invite, err := services.CreateInvite(c.UserContext(), convId, userId, input)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, invite)
+}
+
+func GetInvites(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	invites, err := services.GetInvites(c.UserContext(), convId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, fiber.Map{
+		"invites": invites,
+	})
+}
+
+func RevokeInvite(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+	inviteId := c.Params("inviteId")
+
+	err := services.RevokeInvite(c.UserContext(), convId, inviteId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func GetInvitePreview(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	token := c.Params("token")
+
+	preview, err := services.GetInvitePreview(c.UserContext(), token, userId)
+	if err != nil {
+		logError(c, err)
+		if errors.Is(err, services.ErrInvalidInvite) {
+			return c.SendStatus(404)
+		}
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, preview)
+}
+
+func JoinByInvite(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	token := c.Params("token")
+
+	convId, err := services.JoinByInvite(c.UserContext(), token, userId)
+	if err != nil {
+		logError(c, err)
+		if errors.Is(err, services.ErrInvalidInvite) {
+			return c.SendStatus(404)
+		}
+		if errors.Is(err, services.ErrUserKicked) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "user has been kicked from the conversation",
+			})
+		}
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, convId)
+}
@@ -1,8 +1,6 @@
 package handlers
 
 import (
-	"log"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/yura4ka/crickter/services"
 )
@@ -14,13 +12,13 @@ func CreateMessage(c *fiber.Ctx) error {
 	}
 	userId, _ := c.Locals("userId").(string)
 
-	id, err := services.CreateMessage(input, userId)
+	id, err := services.CreateMessage(c.UserContext(), input, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(id)
+	return sendJSON(c, id)
 }
 
 func EditMessage(c *fiber.Ctx) error {
@@ -31,9 +29,9 @@ func EditMessage(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	messageId := c.Params("id")
 
-	err := services.EditMessage(input, userId, messageId)
+	err := services.EditMessage(c.UserContext(), input, userId, messageId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
@@ -51,26 +49,113 @@ func DeleteMessage(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	messageId := c.Params("id")
 
-	err := services.DeleteMessage(messageId, userId, input.OnlyCreator)
+	err := services.DeleteMessage(c.UserContext(), messageId, userId, input.OnlyCreator)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
 	return c.SendStatus(200)
 }
 
+func GetMessageContext(c *fiber.Ctx) error {
+	userId, _ := c.Locals("userId").(string)
+	messageId := c.Params("id")
+
+	result, err := services.GetMessageContext(c.UserContext(), messageId, userId,
+		c.QueryInt("before", -1), c.QueryInt("after", -1))
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, result)
+}
+
 func GetMessageChanges(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	messageId := c.Params("id")
 
-	changes, err := services.GetMessageChanges(messageId, userId)
+	changes, err := services.GetMessageChanges(c.UserContext(), messageId, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"changes": changes,
 	})
 }
+
+func ToggleMessageReaction(c *fiber.Ctx) error {
+	type Input struct {
+		Emoji string `json:"emoji"`
+	}
+	input := new(Input)
+	if err := c.BodyParser(input); err != nil {
+		return c.SendStatus(400)
+	}
+	userId, _ := c.Locals("userId").(string)
+	messageId := c.Params("id")
+
+	added, err := services.ToggleMessageReaction(c.UserContext(), messageId, userId, input.Emoji)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, fiber.Map{
+		"added": added,
+	})
+}
+
+func RemoveMessageReaction(c *fiber.Ctx) error {
+	type Input struct {
+		Emoji string `json:"emoji"`
+	}
+	input := new(Input)
+	if err := c.BodyParser(input); err != nil {
+		return c.SendStatus(400)
+	}
+	userId, _ := c.Locals("userId").(string)
+	messageId := c.Params("id")
+
+	err := services.RemoveMessageReaction(c.UserContext(), messageId, userId, input.Emoji)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func GetMessageSeen(c *fiber.Ctx) error {
+	userId, _ := c.Locals("userId").(string)
+	messageId := c.Params("id")
+
+	seen, err := services.GetMessageSeen(c.UserContext(), messageId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, fiber.Map{
+		"users": seen,
+	})
+}
+
+func SearchMessages(c *fiber.Ctx) error {
+	q := c.Query("q")
+	if q == "" {
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+
+	result, err := services.SearchMessages(c.UserContext(), userId, q, c.Query("cursor"))
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, result)
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"github.com/yura4ka/crickter/metrics"
+)
+
+var metricsHandler = fasthttpadaptor.NewFastHTTPHandler(
+	promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}),
+)
+
+func GetMetrics(c *fiber.Ctx) error {
+	metricsHandler(c.Context())
+	return nil
+}
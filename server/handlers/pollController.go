@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+func Vote(c *fiber.Ctx) error {
+	input := new(services.VoteRequest)
+	if err := c.BodyParser(input); err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	pollId := c.Params("id")
+
+	poll, err := services.Vote(c.UserContext(), pollId, userId, input)
+	if err != nil {
+		logError(c, err)
+		if errors.Is(err, services.ErrPollClosed) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "poll is closed",
+			})
+		}
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, poll)
+}
+
+func RetractVote(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	pollId := c.Params("id")
+
+	poll, err := services.RetractVote(c.UserContext(), pollId, userId)
+	if err != nil {
+		logError(c, err)
+		if errors.Is(err, services.ErrPollClosed) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "poll is closed",
+			})
+		}
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, poll)
+}
+
+func GetPollVoters(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	pollId := c.Params("id")
+
+	voters, err := services.GetPollVoters(c.UserContext(), pollId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, voters)
+}
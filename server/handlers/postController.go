@@ -1,8 +1,6 @@
 package handlers
 
 import (
-	"log"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/yura4ka/crickter/services"
 )
@@ -10,22 +8,22 @@ import (
 func CreatePost(c *fiber.Ctx) error {
 	input := new(services.PostParams)
 	if err := c.BodyParser(input); err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 	userId := c.Locals("userId").(string)
 
-	if len(input.Text) == 0 && len(input.Media) == 0 {
+	if len(input.Text) == 0 && len(input.Media) == 0 && input.Poll == nil {
 		return c.SendStatus(400)
 	}
 
-	postId, err := services.CreatePost(userId, input)
+	postId, err := services.CreatePost(c.UserContext(), userId, input)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"id": postId,
 	})
 }
@@ -38,10 +36,10 @@ func UpdatePost(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(string)
 	id := c.Params("id")
 
-	post, err := services.GetPostById(id)
+	post, err := services.GetPostById(c.UserContext(), id)
 
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
@@ -49,13 +47,13 @@ func UpdatePost(c *fiber.Ctx) error {
 		return c.SendStatus(403)
 	}
 
-	err = services.UpdatePost(id, input)
+	err = services.UpdatePost(c.UserContext(), id, input)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
@@ -64,19 +62,19 @@ func GetPosts(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	page := c.QueryInt("page", 1)
 
-	posts, err := services.GetPosts(&services.QueryParams{RequestUserId: userId, Page: page, OrderBy: services.SortNew})
+	posts, err := services.GetPosts(c.UserContext(), &services.QueryParams{RequestUserId: userId, Page: page, OrderBy: services.SortNew})
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	hasMore, err := services.HasMorePosts(page)
+	hasMore, err := services.HasMorePosts(c.UserContext(), page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"posts":   posts,
 		"hasMore": hasMore,
 	})
@@ -94,28 +92,80 @@ func ProcessReaction(c *fiber.Ctx) error {
 	}
 	userId := c.Locals("userId").(string)
 
-	err := services.ProcessReaction(userId, input.PostId, input.Liked)
+	err := services.ProcessReaction(c.UserContext(), userId, input.PostId, input.Liked)
 	if err != nil {
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
 
+func GetPostReactionTypes(c *fiber.Ctx) error {
+	return sendJSON(c, services.PostReactionTypes())
+}
+
+func TogglePostReaction(c *fiber.Ctx) error {
+	type Input struct {
+		Type string `json:"type"`
+	}
+
+	input := new(Input)
+	if err := c.BodyParser(input); err != nil {
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	postId := c.Params("id")
+
+	err := services.TogglePostReaction(c.UserContext(), userId, postId, input.Type)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func RemovePostReaction(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	postId := c.Params("id")
+
+	err := services.RemovePostReaction(c.UserContext(), userId, postId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func GetPostReactions(c *fiber.Ctx) error {
+	userId, _ := c.Locals("userId").(string)
+	postId := c.Params("id")
+	page := c.QueryInt("page", 1)
+
+	reactions, err := services.GetPostReactions(c.UserContext(), postId, userId, c.Query("type"), page)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, reactions)
+}
+
 func GetPostById(c *fiber.Ctx) error {
 	id := c.Params("id")
 	userId, _ := c.Locals("userId").(string)
 
-	post, err := services.QueryPostById(id, userId)
+	post, err := services.QueryPostById(c.UserContext(), id, userId)
 
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(post)
+	return sendJSON(c, post)
 }
 
 func ProcessFavorite(c *fiber.Ctx) error {
@@ -130,12 +180,12 @@ func ProcessFavorite(c *fiber.Ctx) error {
 
 	userId, _ := c.Locals("userId").(string)
 
-	if err := services.ProcessFavorite(input.PostId, userId); err != nil {
-		log.Print(err)
+	if err := services.ProcessFavorite(c.UserContext(), input.PostId, userId); err != nil {
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
@@ -144,18 +194,18 @@ func GetFavoritePosts(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	page := c.QueryInt("page", 1)
 
-	posts, err := services.GetFavoritePosts(userId, page)
+	posts, err := services.GetFavoritePosts(c.UserContext(), userId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
-	hasMore, err := services.HasMoreFavorite(userId, page)
+	hasMore, err := services.HasMoreFavorite(c.UserContext(), userId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"posts":   posts,
 		"hasMore": hasMore,
 	})
@@ -165,33 +215,53 @@ func DeletePost(c *fiber.Ctx) error {
 	postId := c.Params("id")
 	userId, _ := c.Locals("userId").(string)
 
-	err := services.DeletePost(postId, userId)
+	err := services.DeletePost(c.UserContext(), postId, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
 
+func SharePost(c *fiber.Ctx) error {
+	input := new(services.SharePostRequest)
+	if err := c.BodyParser(input); err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+	postId := c.Params("id")
+	userId := c.Locals("userId").(string)
+
+	shared, err := services.SharePost(c.UserContext(), postId, userId, input)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, fiber.Map{
+		"messages": shared,
+	})
+}
+
 func GetPostHistory(c *fiber.Ctx) error {
 	postId := c.Params("id")
 	userId, _ := c.Locals("userId").(string)
 
-	post, err := services.GetPostById(postId)
+	post, err := services.GetPostById(c.UserContext(), postId)
 	if err != nil || post.UserId != userId {
 		return c.SendStatus(400)
 	}
 
-	history, err := services.GetPostHistory(postId)
+	history, err := services.GetPostHistory(c.UserContext(), postId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"changes": history.Changes,
 		"media":   history.Media,
 	})
@@ -205,19 +275,19 @@ func GetPostsBySearch(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	page := c.QueryInt("page", 1)
 
-	posts, err := services.SearchPosts(q, page, userId)
+	posts, err := services.SearchPosts(c.UserContext(), q, page, userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	hasMore, err := services.HasSearchMorePosts(q, page)
+	hasMore, err := services.HasSearchMorePosts(c.UserContext(), q, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"posts":   posts,
 		"hasMore": hasMore,
 	})
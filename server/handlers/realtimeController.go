@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/realtime"
+	"github.com/yura4ka/crickter/services"
+)
+
+const (
+	socketPingInterval = 30 * time.Second
+	socketReadTimeout  = 2 * socketPingInterval
+	socketWriteTimeout = 10 * time.Second
+)
+
+type socketMessage struct {
+	Type           string `json:"type"`
+	ConversationId string `json:"conversationId"`
+	IsTyping       *bool  `json:"isTyping"`
+}
+
+var Realtime = websocket.New(func(conn *websocket.Conn) {
+	userId, _ := conn.Locals("userId").(string)
+	logger := slog.Default().With(slog.String("userId", userId))
+	ctx := logging.WithLogger(context.Background(), logger)
+
+	client := realtime.Register(userId)
+	if client == nil {
+		return
+	}
+	defer realtime.Unregister(client)
+
+	if err := services.UserConnected(ctx, userId); err != nil {
+		logger.Error("failed updating presence", logging.Err(err))
+	}
+	defer func() {
+		if err := services.UserDisconnected(ctx, userId); err != nil {
+			logger.Error("failed updating presence", logging.Err(err))
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeEvents(conn, client)
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	})
+
+	for {
+		var m socketMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			break
+		}
+
+		switch m.Type {
+		case "typing":
+			isTyping := m.IsTyping == nil || *m.IsTyping
+			if err := services.SetTyping(ctx, m.ConversationId, userId, isTyping); err != nil {
+				logger.Warn("failed setting typing", logging.Err(err))
+			}
+		}
+	}
+
+	realtime.Unregister(client)
+	<-done
+})
+
+func writeEvents(conn *websocket.Conn, client *realtime.Client) {
+	ticker := time.NewTicker(socketPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-client.Events():
+			conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				conn.Close()
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				conn.Close()
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func SetTyping(c *fiber.Ctx) error {
+	type Input struct {
+		IsTyping *bool `json:"isTyping"`
+	}
+	input := new(Input)
+	if err := c.BodyParser(input); err != nil {
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	convId := c.Params("id")
+
+	isTyping := input.IsTyping == nil || *input.IsTyping
+	err := services.SetTyping(c.UserContext(), convId, userId, isTyping)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
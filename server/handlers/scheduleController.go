@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+func GetScheduled(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+
+	scheduled, err := services.GetScheduled(c.UserContext(), userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, scheduled)
+}
+
+func ReschedulePost(c *fiber.Ctx) error {
+	input := new(services.RescheduleRequest)
+	if err := c.BodyParser(input); err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	postId := c.Params("id")
+
+	err := services.ReschedulePost(c.UserContext(), postId, userId, input)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func RescheduleMessage(c *fiber.Ctx) error {
+	input := new(services.RescheduleRequest)
+	if err := c.BodyParser(input); err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+	userId := c.Locals("userId").(string)
+	messageId := c.Params("id")
+
+	err := services.RescheduleMessage(c.UserContext(), messageId, userId, input)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func CancelScheduledPost(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	postId := c.Params("id")
+
+	err := services.CancelScheduledPost(c.UserContext(), postId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
+
+func CancelScheduledMessage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	messageId := c.Params("id")
+
+	err := services.CancelScheduledMessage(c.UserContext(), messageId, userId)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return c.SendStatus(200)
+}
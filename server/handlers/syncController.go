@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+func Sync(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	since := c.Query("since")
+
+	result, err := services.Sync(c.UserContext(), userId, since)
+	if err != nil {
+		logError(c, err)
+		return c.SendStatus(400)
+	}
+
+	return sendJSON(c, result)
+}
@@ -1,35 +1,33 @@
 package handlers
 
 import (
-	"log"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/yura4ka/crickter/services"
 )
 
 func GetPopularTags(c *fiber.Ctx) error {
-	tags, err := services.GetTags(-1)
+	tags, err := services.GetTags(c.UserContext(), -1)
 	if err != nil {
 		return c.SendStatus(500)
 	}
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"tags": tags,
 	})
 }
 
 func GetTags(c *fiber.Ctx) error {
 	page := c.QueryInt("page", 1)
-	tags, err := services.GetTags(page)
+	tags, err := services.GetTags(c.UserContext(), page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(500)
 	}
-	hasMore, err := services.HasMoreTags(page)
+	hasMore, err := services.HasMoreTags(c.UserContext(), page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(500)
 	}
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"tags":    tags,
 		"hasMore": hasMore,
 	})
@@ -40,7 +38,7 @@ func GetTagPosts(c *fiber.Ctx) error {
 	page := c.QueryInt("page", 1)
 	tag := c.Params("tag")
 
-	posts, err := services.GetPosts(
+	posts, err := services.GetPosts(c.UserContext(),
 		&services.QueryParams{Tag: tag, Page: page, RequestUserId: userId, OrderBy: services.SortNew},
 	)
 
@@ -48,13 +46,13 @@ func GetTagPosts(c *fiber.Ctx) error {
 		return c.SendStatus(400)
 	}
 
-	hasMore, err := services.HasTagMorePosts(tag, page)
+	hasMore, err := services.HasTagMorePosts(c.UserContext(), tag, page)
 
 	if err != nil {
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"posts":   posts,
 		"hasMore": hasMore,
 	})
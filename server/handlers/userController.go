@@ -1,8 +1,6 @@
 package handlers
 
 import (
-	"log"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/yura4ka/crickter/services"
 )
@@ -10,12 +8,12 @@ import (
 func GetUserInfo(c *fiber.Ctx) error {
 	id := c.Params("userId")
 	requestUserId, _ := c.Locals("userId").(string)
-	user, err := services.GetUserInfo(id, requestUserId)
+	user, err := services.GetUserInfo(c.UserContext(), id, requestUserId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
-	return c.JSON(user)
+	return sendJSON(c, user)
 }
 
 func GetUserPosts(c *fiber.Ctx) error {
@@ -23,21 +21,21 @@ func GetUserPosts(c *fiber.Ctx) error {
 	requestUserId, _ := c.Locals("userId").(string)
 	page := c.QueryInt("page", 1)
 
-	posts, err := services.GetPosts(
+	posts, err := services.GetPosts(c.UserContext(),
 		&services.QueryParams{UserId: userId, RequestUserId: requestUserId, Page: page, OrderBy: services.SortNew},
 	)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	hasMore, err := services.HasUserMorePosts(userId, page)
+	hasMore, err := services.HasUserMorePosts(c.UserContext(), userId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"posts":   posts,
 		"hasMore": hasMore,
 	})
@@ -52,13 +50,13 @@ func FollowHandler(c *fiber.Ctx, follow bool) error {
 
 	var err error
 	if follow {
-		err = services.HandleFollow(userId, followerId)
+		err = services.HandleFollow(c.UserContext(), userId, followerId)
 	} else {
-		err = services.HandleUnFollow(userId, followerId)
+		err = services.HandleUnFollow(c.UserContext(), userId, followerId)
 	}
 
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
@@ -78,19 +76,19 @@ func GetFollowing(c *fiber.Ctx) error {
 	requestUserId, _ := c.Locals("userId").(string)
 	page := c.QueryInt("page", 1)
 
-	following, err := services.GetFollowing(userId, requestUserId, page)
+	following, err := services.GetFollowing(c.UserContext(), userId, requestUserId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	hasMore, err := services.HasMoreFollowing(userId, page)
+	hasMore, err := services.HasMoreFollowing(c.UserContext(), userId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"users":   following,
 		"hasMore": hasMore,
 	})
@@ -101,19 +99,19 @@ func GetFollowers(c *fiber.Ctx) error {
 	requestUserId, _ := c.Locals("userId").(string)
 	page := c.QueryInt("page", 1)
 
-	followers, err := services.GetFollowers(userId, requestUserId, page)
+	followers, err := services.GetFollowers(c.UserContext(), userId, requestUserId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	hasMore, err := services.HasMoreFollowers(userId, page)
+	hasMore, err := services.HasMoreFollowers(c.UserContext(), userId, page)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"users":   followers,
 		"hasMore": hasMore,
 	})
@@ -122,30 +120,30 @@ func GetFollowers(c *fiber.Ctx) error {
 func ChangeUser(c *fiber.Ctx) error {
 	input := new(services.ChangeUserRequest)
 	if err := c.BodyParser(input); err != nil {
-		log.Print(err)
+		logError(c, err)
 		return c.SendStatus(400)
 	}
 	userId, _ := c.Locals("userId").(string)
 
-	if err := services.ChangeUser(userId, input); err != nil {
+	if err := services.ChangeUser(c.UserContext(), userId, input); err != nil {
 		c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
 
 func DeleteUser(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
-	err := services.DeleteUser(userId)
+	err := services.DeleteUser(c.UserContext(), userId)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		c.SendStatus(400)
 	}
 
 	c.Cookie(services.ClearRefreshCookie())
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
@@ -154,13 +152,13 @@ func BlockUser(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	blockUser := c.Params("userId")
 
-	err := services.BlockUser(userId, blockUser)
+	err := services.BlockUser(c.UserContext(), userId, blockUser)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
@@ -169,13 +167,13 @@ func UnblockUser(c *fiber.Ctx) error {
 	userId, _ := c.Locals("userId").(string)
 	blockUser := c.Params("userId")
 
-	err := services.UnblockUser(userId, blockUser)
+	err := services.UnblockUser(c.UserContext(), userId, blockUser)
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"message": "Ok",
 	})
 }
@@ -189,17 +187,17 @@ func IsUserBlocked(c *fiber.Ctx) error {
 	var isBlocked bool
 
 	if isMeBlocked {
-		isBlocked, err = services.IsUserBlocked(checkUser, userId)
+		isBlocked, err = services.IsUserBlocked(c.UserContext(), checkUser, userId)
 	} else {
-		isBlocked, err = services.IsUserBlocked(userId, checkUser)
+		isBlocked, err = services.IsUserBlocked(c.UserContext(), userId, checkUser)
 	}
 
 	if err != nil {
-		log.Print(err)
+		logError(c, err)
 		c.SendStatus(400)
 	}
 
-	return c.JSON(fiber.Map{
+	return sendJSON(c, fiber.Map{
 		"isBlocked": isBlocked,
 	})
 }
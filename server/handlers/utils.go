@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func logError(c *fiber.Ctx, err error) {
+	trace.SpanFromContext(c.UserContext()).RecordError(err)
+	logging.FromContext(c.UserContext()).Error("request failed", logging.Err(err))
+}
+
+func sendJSON(c *fiber.Ctx, data any) error {
+	_, span := tracing.Start(c.UserContext(), "json.encode")
+	defer span.End()
+
+	return c.JSON(data)
+}
@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+)
+
+const (
+	cleanupKind     = "jobs.cleanup"
+	cleanupInterval = time.Hour
+	doneRetention   = 7 * 24 * time.Hour
+)
+
+type cleanupPayload struct{}
+
+func registerCleanup() {
+	Register(cleanupKind, func(ctx context.Context, _ cleanupPayload) error {
+		_, err := db.Client.ExecContext(ctx, `
+			DELETE FROM jobs
+			WHERE status = 'done' AND updated_at < $1;
+		`, time.Now().Add(-doneRetention))
+		return err
+	})
+	Schedule(cleanupKind, cleanupInterval, cleanupPayload{})
+}
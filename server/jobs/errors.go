@@ -0,0 +1,17 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownKind = errors.New("unknown job kind")
+var errLockExpired = errors.New("lock expired on the last attempt")
+
+type panicError struct {
+	value any
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("job panicked: %v", e.value)
+}
@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+)
+
+const defaultMaxAttempts = 5
+
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+)
+
+type lastAttemptKey struct{}
+
+// LastAttempt reports whether the running job is on its final attempt, so
+// that its handler can record the failure before the job goes dead.
+func LastAttempt(ctx context.Context) bool {
+	last, _ := ctx.Value(lastAttemptKey{}).(bool)
+	return last
+}
+
+// Register adds a handler for the given job kind. The payload is decoded
+// into T before the handler is called.
+func Register[T any](kind string, handler func(ctx context.Context, payload T) error) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	handlers[kind] = func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return err
+		}
+		return handler(ctx, payload)
+	}
+}
+
+func getHandler(kind string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	h, ok := handlers[kind]
+	return h, ok
+}
+
+type enqueueOptions struct {
+	runAt       time.Time
+	maxAttempts int
+	uniqueKey   string
+}
+
+type Option func(*enqueueOptions)
+
+func RunAt(t time.Time) Option {
+	return func(o *enqueueOptions) {
+		o.runAt = t
+	}
+}
+
+func MaxAttempts(n int) Option {
+	return func(o *enqueueOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// UniqueKey makes enqueueing idempotent: a second job with the same key
+// is silently dropped.
+func UniqueKey(key string) Option {
+	return func(o *enqueueOptions) {
+		o.uniqueKey = key
+	}
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func Enqueue(ctx context.Context, kind string, payload any, opts ...Option) error {
+	return enqueue(ctx, db.Client, kind, payload, opts...)
+}
+
+// EnqueueTx adds a job as part of tx, so it only becomes visible to the
+// workers once tx is committed.
+func EnqueueTx(ctx context.Context, tx *sql.Tx, kind string, payload any, opts ...Option) error {
+	return enqueue(ctx, tx, kind, payload, opts...)
+}
+
+func enqueue(ctx context.Context, e execer, kind string, payload any, opts ...Option) error {
+	o := enqueueOptions{runAt: time.Now(), maxAttempts: defaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var uniqueKey sql.NullString
+	if o.uniqueKey != "" {
+		uniqueKey = sql.NullString{String: o.uniqueKey, Valid: true}
+	}
+
+	_, err = e.ExecContext(ctx, `
+		INSERT INTO jobs (kind, payload, run_at, max_attempts, unique_key)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (unique_key) DO NOTHING;
+	`, kind, data, o.runAt, o.maxAttempts, uniqueKey)
+
+	return err
+}
@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/yura4ka/crickter/logging"
+)
+
+type schedule struct {
+	kind    string
+	every   time.Duration
+	payload any
+}
+
+var (
+	schedulesMu sync.Mutex
+	schedules   []schedule
+)
+
+// Schedule enqueues a job of the given kind once per interval. Every
+// instance runs the scheduler, but the unique key built from the interval
+// slot makes sure only one job is created per slot. Only fixed intervals
+// are supported, slots are aligned to multiples of every since the epoch.
+func Schedule(kind string, every time.Duration, payload any) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+
+	schedules = append(schedules, schedule{kind: kind, every: every, payload: payload})
+}
+
+func startScheduler(ctx context.Context, wg *sync.WaitGroup) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+
+	for _, s := range schedules {
+		s := s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runSchedule(ctx, s)
+		}()
+	}
+}
+
+func runSchedule(ctx context.Context, s schedule) {
+	ticker := time.NewTicker(s.every)
+	defer ticker.Stop()
+
+	for {
+		slot := time.Now().Truncate(s.every)
+		err := Enqueue(ctx, s.kind, s.payload,
+			RunAt(slot),
+			UniqueKey(fmt.Sprintf("%s@%d", s.kind, slot.Unix())),
+		)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("failed scheduling job", slog.String("kind", s.kind), logging.Err(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
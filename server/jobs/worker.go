@@ -0,0 +1,204 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	pollInterval = time.Second
+	lockTimeout  = 10 * time.Minute
+	// jobTimeout stops handlers before their lock expires, so a slow job is
+	// not claimed again by another worker while it is still running.
+	jobTimeout  = lockTimeout - time.Minute
+	baseBackoff = 10 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+type job struct {
+	Id          string
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+// Start launches the worker pool and the scheduler. Both stop once ctx is
+// cancelled; wg is released when the last of them has returned.
+func Start(ctx context.Context, workers int, wg *sync.WaitGroup) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	registerCleanup()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(ctx)
+		}()
+	}
+
+	startScheduler(ctx, wg)
+}
+
+func work(ctx context.Context) {
+	for {
+		found, err := runNext(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("failed running job", logging.Err(err))
+		}
+
+		if found && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func claim(ctx context.Context) (*job, error) {
+	var j job
+
+	// a job whose lock expired took its worker down or lost it; once that
+	// happened on the last attempt it goes to the dead letter instead of
+	// being run again
+	err := db.Client.QueryRowContext(ctx, `
+		WITH lost AS (
+			UPDATE jobs SET status = 'dead', locked_at = NULL, last_error = $2
+			WHERE status = 'running' AND locked_at < Now() - make_interval(secs => $1)
+				AND attempts >= max_attempts
+		)
+		UPDATE jobs SET status = 'running', locked_at = Now(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE status = 'pending' AND run_at <= Now()
+				OR status = 'running' AND locked_at < Now() - make_interval(secs => $1)
+					AND attempts < max_attempts
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts, max_attempts;
+	`, lockTimeout.Seconds(), errLockExpired.Error()).Scan(&j.Id, &j.Kind, &j.Payload, &j.Attempts, &j.MaxAttempts)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+func runNext(ctx context.Context) (bool, error) {
+	j, err := claim(ctx)
+	if err != nil || j == nil {
+		return false, err
+	}
+
+	jobCtx, span := tracing.Start(ctx, "jobs."+j.Kind,
+		attribute.String("job.id", j.Id),
+		attribute.Int("job.attempt", j.Attempts),
+	)
+	defer span.End()
+
+	logger := slog.Default().With(slog.String("jobId", j.Id), slog.String("kind", j.Kind))
+	jobCtx = logging.WithLogger(jobCtx, logger)
+	jobCtx = context.WithValue(jobCtx, lastAttemptKey{}, j.Attempts >= j.MaxAttempts)
+	jobCtx, cancel := context.WithTimeout(jobCtx, jobTimeout)
+	defer cancel()
+
+	handler, ok := getHandler(j.Kind)
+	if !ok {
+		err = ErrUnknownKind
+		j.Attempts = j.MaxAttempts
+	} else {
+		err = safeRun(jobCtx, handler, j.Payload)
+	}
+
+	// the job was interrupted by shutdown, give the attempt back
+	if err != nil && ctx.Err() != nil {
+		_, resetErr := db.Client.ExecContext(context.Background(), `
+			UPDATE jobs SET status = 'pending', locked_at = NULL, attempts = attempts - 1
+			WHERE id = $1;
+		`, j.Id)
+		return true, resetErr
+	}
+
+	// the outcome is recorded even when shutdown begins meanwhile,
+	// otherwise a finished job would be run again once its lock expires
+	ctx = context.WithoutCancel(ctx)
+
+	if err == nil {
+		metrics.Jobs.WithLabelValues(j.Kind, "done").Inc()
+		_, err = db.Client.ExecContext(ctx, `
+			UPDATE jobs SET status = 'done', locked_at = NULL, last_error = NULL
+			WHERE id = $1;
+		`, j.Id)
+		return true, err
+	}
+
+	span.RecordError(err)
+
+	if j.Attempts >= j.MaxAttempts {
+		metrics.Jobs.WithLabelValues(j.Kind, "dead").Inc()
+		logger.Error("job moved to dead letter", logging.Err(err))
+		_, err = db.Client.ExecContext(ctx, `
+			UPDATE jobs SET status = 'dead', locked_at = NULL, last_error = $1
+			WHERE id = $2;
+		`, err.Error(), j.Id)
+		return true, err
+	}
+
+	metrics.Jobs.WithLabelValues(j.Kind, "retry").Inc()
+	logger.Warn("job failed, retrying", logging.Err(err), slog.Int("attempt", j.Attempts))
+	_, err = db.Client.ExecContext(ctx, `
+		UPDATE jobs SET status = 'pending', locked_at = NULL, last_error = $1, run_at = $2
+		WHERE id = $3;
+	`, err.Error(), time.Now().Add(backoff(j.Attempts)), j.Id)
+
+	return true, err
+}
+
+func safeRun(ctx context.Context, handler Handler, payload json.RawMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError{r}
+		}
+	}()
+
+	return handler(ctx, payload)
+}
+
+// backoff doubles the delay with every attempt and adds up to 20% jitter
+// so that failing jobs don't retry in lockstep.
+func backoff(attempt int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type ctxKey struct{}
+
+var level = new(slog.LevelVar)
+
+func Setup() {
+	if err := SetLevel(os.Getenv("LOG_LEVEL")); err != nil {
+		level.Set(slog.LevelInfo)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+}
+
+func SetLevel(s string) error {
+	if s == "" {
+		level.Set(slog.LevelInfo)
+		return nil
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+func GetLevel() string {
+	return level.Level().String()
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// Err turns an error into a log attribute, expanding postgres errors
+// into their code, constraint and table so failed queries can be traced.
+func Err(err error) slog.Attr {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return slog.Group("err",
+			slog.String("message", pqErr.Message),
+			slog.String("code", string(pqErr.Code)),
+			slog.String("detail", pqErr.Detail),
+			slog.String("constraint", pqErr.Constraint),
+			slog.String("table", pqErr.Table),
+			slog.String("where", pqErr.Where),
+		)
+	}
+
+	if err == nil {
+		return slog.String("err", "")
+	}
+	return slog.String("err", err.Error())
+}
@@ -1,16 +1,32 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/joho/godotenv"
 	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/jobs"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/middleware"
+	"github.com/yura4ka/crickter/realtime"
 	"github.com/yura4ka/crickter/router"
+	"github.com/yura4ka/crickter/services"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	shutdownTimeout   = 15 * time.Second
+	defaultJobWorkers = 4
 )
 
 func init() {
@@ -28,16 +44,37 @@ func init() {
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logging.Setup()
+
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		log.Fatalf("failed setting up tracing: %v", err)
+	}
+
 	app := fiber.New()
-	app.Use(logger.New())
+	app.Use(middleware.RequestId)
+	app.Use(middleware.Tracing)
+	app.Use(middleware.RequestLogger)
+	app.Use(middleware.Metrics)
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     os.Getenv("CLIENT_ADDR"),
 		AllowCredentials: true,
+		ExposeHeaders:    middleware.RequestIdHeader,
 	}))
 
 	db.Connect()
+	metrics.RegisterDB(db.Client)
 	router.SetupRouter(app)
 
+	// background workers receive ctx and must return once it is cancelled
+	var workers sync.WaitGroup
+
+	services.RegisterJobs()
+	jobs.Start(ctx, jobWorkers(), &workers)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = ":8000"
@@ -45,5 +82,55 @@ func main() {
 		port = ":" + port
 	}
 
-	log.Fatal(app.Listen(port))
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(port)
+	}()
+
+	select {
+	case err := <-listenErr:
+		if err != nil {
+			log.Print(err)
+		}
+		stop()
+	case <-ctx.Done():
+		log.Print("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	realtime.Shutdown()
+
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		log.Print(err)
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		log.Print("background workers did not stop in time")
+	}
+
+	if err := db.Close(); err != nil {
+		log.Print(err)
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Print(err)
+	}
+}
+
+func jobWorkers() int {
+	n, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || n < 1 {
+		return defaultJobWorkers
+	}
+	return n
 }
@@ -10,16 +10,16 @@ import (
 func ParseAuth(c *fiber.Ctx) error {
 	cookie := strings.Split(c.Get("Authorization"), " ")
 	if len(cookie) != 2 || cookie[0] != "Bearer" {
-		c.Locals("userId", "")
+		setUserId(c, "")
 		return c.Next()
 	}
 
 	payload, err := services.VerifyAccessToken(cookie[1])
 	if err != nil {
-		c.Locals("userId", "")
+		setUserId(c, "")
 		return c.Next()
 	}
 
-	c.Locals("userId", payload.Id)
+	setUserId(c, payload.Id)
 	return c.Next()
 }
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/yura4ka/crickter/logging"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+func RequestId(c *fiber.Ctx) error {
+	id := c.Get(RequestIdHeader)
+	if id == "" || len(id) > 64 {
+		id = utils.UUIDv4()
+	}
+
+	c.Set(RequestIdHeader, id)
+	c.Locals("requestId", id)
+
+	logger := slog.Default().With(slog.String("requestId", id))
+	c.SetUserContext(logging.WithLogger(c.UserContext(), logger))
+
+	return c.Next()
+}
+
+func setUserId(c *fiber.Ctx, userId string) {
+	c.Locals("userId", userId)
+	if userId == "" {
+		return
+	}
+
+	logger := logging.FromContext(c.UserContext()).With(slog.String("userId", userId))
+	c.SetUserContext(logging.WithLogger(c.UserContext(), logger))
+}
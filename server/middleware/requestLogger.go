@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/logging"
+)
+
+func RequestLogger(c *fiber.Ctx) error {
+	start := time.Now()
+	chainErr := c.Next()
+
+	if chainErr != nil {
+		if err := c.App().ErrorHandler(c, chainErr); err != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	status := c.Response().StatusCode()
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelError
+	} else if status >= 400 {
+		level = slog.LevelWarn
+	}
+
+	logging.FromContext(c.UserContext()).LogAttrs(c.UserContext(), level, "request",
+		slog.String("method", c.Method()),
+		slog.String("path", c.Path()),
+		slog.String("route", c.Route().Path),
+		slog.Int("status", status),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("ip", c.IP()),
+	)
+
+	return nil
+}
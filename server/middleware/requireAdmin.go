@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RequireAdmin(c *fiber.Ctx) error {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return c.SendStatus(404)
+	}
+
+	header := strings.Split(c.Get("Authorization"), " ")
+	if len(header) != 2 || header[0] != "Bearer" ||
+		subtle.ConstantTimeCompare([]byte(header[1]), []byte(token)) != 1 {
+		return c.SendStatus(401)
+	}
+
+	return c.Next()
+}
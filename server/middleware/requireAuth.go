@@ -2,11 +2,11 @@ package middleware
 
 import (
 	"errors"
-	"log"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/yura4ka/crickter/logging"
 	"github.com/yura4ka/crickter/services"
 )
 
@@ -20,10 +20,10 @@ func RequireAuth(c *fiber.Ctx) error {
 	if errors.Is(err, jwt.ErrTokenExpired) {
 		return c.SendStatus(401)
 	} else if err != nil {
-		log.Print(err)
+		logging.FromContext(c.UserContext()).Warn("invalid access token", logging.Err(err))
 		return c.SendStatus(400)
 	}
 
-	c.Locals("userId", payload.Id)
+	setUserId(c, payload.Id)
 	return c.Next()
 }
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/services"
+)
+
+// RequireSocketAuth authenticates websocket upgrades. Browsers cannot set
+// headers on websocket requests, so the access token comes in the query.
+func RequireSocketAuth(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.SendStatus(426)
+	}
+
+	payload, err := services.VerifyAccessToken(c.Query("token"))
+	if err != nil {
+		return c.SendStatus(401)
+	}
+
+	setUserId(c, payload.Id)
+	return c.Next()
+}
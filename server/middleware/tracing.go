@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func Tracing(c *fiber.Ctx) error {
+	carrier := propagation.MapCarrier{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		carrier[strings.ToLower(string(key))] = string(value)
+	})
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+
+	ctx, span := tracing.StartServer(ctx, c.Method()+" "+c.Path(),
+		semconv.HTTPRequestMethodKey.String(c.Method()),
+		semconv.URLPath(c.Path()),
+		semconv.ClientAddress(c.IP()),
+	)
+	defer span.End()
+
+	if span.SpanContext().IsValid() {
+		logger := logging.FromContext(ctx).With(slog.String("traceId", span.SpanContext().TraceID().String()))
+		ctx = logging.WithLogger(ctx, logger)
+	}
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if e, ok := err.(*fiber.Error); ok {
+		status = e.Code
+	}
+
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(
+		semconv.HTTPRoute(route),
+		semconv.HTTPResponseStatusCode(status),
+	)
+	if err != nil || status >= 500 {
+		span.SetStatus(codes.Error, "")
+	}
+
+	return err
+}
@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TYPE job_status AS ENUM ('pending', 'running', 'done', 'dead');
+
+CREATE TABLE jobs (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  updated_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  kind VARCHAR(64) NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}',
+  status job_status NOT NULL DEFAULT 'pending',
+  attempts SMALLINT NOT NULL DEFAULT 0,
+  max_attempts SMALLINT NOT NULL DEFAULT 5,
+  run_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  locked_at TIMESTAMPTZ,
+  last_error TEXT,
+  unique_key VARCHAR(128),
+
+  CHECK (max_attempts > 0)
+);
+
+CREATE INDEX jobs_pending_idx ON jobs(run_at) WHERE status = 'pending';
+CREATE INDEX jobs_running_idx ON jobs(locked_at) WHERE status = 'running';
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs(unique_key);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON jobs
+FOR EACH ROW
+EXECUTE PROCEDURE update_timestamp();
+
+-- +goose Down
+DROP TABLE IF EXISTS jobs CASCADE;
+DROP TYPE IF EXISTS job_status CASCADE;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN hide_last_seen BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE user_last_seen (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  last_seen_at TIMESTAMPTZ DEFAULT Now() NOT NULL
+);
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS hide_last_seen;
+DROP TABLE IF EXISTS user_last_seen;
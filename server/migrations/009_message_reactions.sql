@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE message_reactions (
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  emoji VARCHAR(32) NOT NULL,
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  PRIMARY KEY (message_id, user_id, emoji),
+
+  CHECK (char_length(emoji) > 0)
+);
+
+CREATE INDEX message_reactions_message_idx ON message_reactions(message_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS message_reactions;
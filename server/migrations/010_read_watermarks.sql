@@ -0,0 +1,40 @@
+-- +goose Up
+ALTER TABLE participants
+ADD COLUMN last_read_message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+ADD COLUMN last_read_at TIMESTAMPTZ;
+
+UPDATE participants AS p SET last_read_at = r.last_read_at, last_read_message_id = r.message_id
+FROM (
+  SELECT DISTINCT ON (m.conversation_id, mr.user_id)
+    m.conversation_id, mr.user_id, m.id AS message_id, m.created_at AS last_read_at
+  FROM message_read AS mr
+  INNER JOIN messages AS m ON mr.message_id = m.id
+  ORDER BY m.conversation_id, mr.user_id, m.created_at DESC
+) r
+WHERE p.conversation_id = r.conversation_id AND p.user_id = r.user_id;
+
+CREATE INDEX message_conversation_created_idx ON messages(conversation_id, created_at);
+
+DROP TABLE IF EXISTS message_read;
+
+-- +goose Down
+CREATE TABLE message_read (
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX message_read_idx ON message_read(message_id);
+
+INSERT INTO message_read (message_id, user_id)
+SELECT m.id, p.user_id
+FROM participants AS p
+INNER JOIN messages AS m ON p.conversation_id = m.conversation_id
+WHERE m.created_at <= p.last_read_at;
+
+DROP INDEX IF EXISTS message_conversation_created_idx;
+
+ALTER TABLE participants
+DROP COLUMN IF EXISTS last_read_message_id,
+DROP COLUMN IF EXISTS last_read_at;
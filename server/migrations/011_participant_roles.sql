@@ -0,0 +1,61 @@
+-- +goose Up
+CREATE TYPE participant_role AS ENUM ('owner', 'admin', 'member');
+CREATE TYPE permission_level AS ENUM ('everyone', 'admins', 'owner');
+
+ALTER TABLE participants
+ADD COLUMN role participant_role NOT NULL DEFAULT 'member',
+ADD COLUMN role_updated_at TIMESTAMPTZ DEFAULT Now() NOT NULL;
+
+UPDATE participants AS p SET role = 'owner'
+FROM conversations AS c
+WHERE p.conversation_id = c.id AND c.type = 'group' AND p.user_id = c.creator_id;
+
+ALTER TABLE conversations
+ADD COLUMN avatar_url TEXT,
+ADD COLUMN avatar_type VARCHAR(32),
+ADD COLUMN add_users_permission permission_level NOT NULL DEFAULT 'admins',
+ADD COLUMN edit_info_permission permission_level NOT NULL DEFAULT 'admins',
+ADD COLUMN pin_messages_permission permission_level NOT NULL DEFAULT 'admins',
+ADD COLUMN delete_messages_permission permission_level NOT NULL DEFAULT 'admins';
+
+UPDATE conversations
+SET add_users_permission = CASE WHEN can_add_users THEN 'everyone'::permission_level ELSE 'owner' END,
+  edit_info_permission = 'owner';
+
+ALTER TABLE conversations DROP COLUMN can_add_users;
+
+ALTER TABLE conversations
+ADD CONSTRAINT private_conversation_check
+CHECK (type = 'group' OR has_invite_link = FALSE AND avatar_url IS NULL);
+
+ALTER TABLE conversations
+ADD CONSTRAINT valid_conversation_avatar
+CHECK (avatar_url IS NULL AND avatar_type IS NULL
+  OR avatar_url IS NOT NULL AND avatar_type IS NOT NULL);
+
+-- +goose Down
+ALTER TABLE conversations
+DROP CONSTRAINT IF EXISTS private_conversation_check,
+DROP CONSTRAINT IF EXISTS valid_conversation_avatar;
+
+ALTER TABLE conversations ADD COLUMN can_add_users BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE conversations SET can_add_users = add_users_permission = 'everyone';
+
+ALTER TABLE conversations
+ADD CHECK (type = 'group' OR
+  (type = 'private' AND can_add_users = FALSE AND has_invite_link = FALSE));
+
+ALTER TABLE conversations
+DROP COLUMN avatar_url,
+DROP COLUMN avatar_type,
+DROP COLUMN add_users_permission,
+DROP COLUMN edit_info_permission,
+DROP COLUMN pin_messages_permission,
+DROP COLUMN delete_messages_permission;
+
+ALTER TABLE participants
+DROP COLUMN role,
+DROP COLUMN role_updated_at;
+
+DROP TYPE IF EXISTS participant_role;
+DROP TYPE IF EXISTS permission_level;
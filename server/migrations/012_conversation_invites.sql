@@ -0,0 +1,39 @@
+-- +goose Up
+CREATE TABLE conversation_invites (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  token VARCHAR(64) NOT NULL UNIQUE,
+  conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+  creator_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  expires_at TIMESTAMPTZ,
+  max_uses INT,
+  uses INT NOT NULL DEFAULT 0,
+  revoked_at TIMESTAMPTZ,
+  CHECK (max_uses IS NULL OR max_uses > 0),
+  CHECK (uses >= 0)
+);
+
+CREATE INDEX conversation_invites_conversation ON conversation_invites(conversation_id);
+
+ALTER TABLE conversations DROP CONSTRAINT private_conversation_check;
+ALTER TABLE conversations DROP COLUMN has_invite_link;
+
+ALTER TABLE conversations
+ADD CONSTRAINT private_conversation_check
+CHECK (type = 'group' OR avatar_url IS NULL);
+
+-- +goose Down
+ALTER TABLE conversations DROP CONSTRAINT private_conversation_check;
+ALTER TABLE conversations ADD COLUMN has_invite_link BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE conversations AS c SET has_invite_link = TRUE
+WHERE EXISTS (
+  SELECT 1 FROM conversation_invites AS i
+  WHERE i.conversation_id = c.id AND i.revoked_at IS NULL
+);
+
+ALTER TABLE conversations
+ADD CONSTRAINT private_conversation_check
+CHECK (type = 'group' OR has_invite_link = FALSE AND avatar_url IS NULL);
+
+DROP TABLE IF EXISTS conversation_invites;
@@ -0,0 +1,85 @@
+-- +goose Up
+CREATE TYPE message_kind AS ENUM ('user', 'system');
+
+ALTER TABLE messages
+ADD COLUMN kind message_kind NOT NULL DEFAULT 'user',
+ADD COLUMN event_type VARCHAR(32),
+ADD COLUMN event_user_ids UUID[],
+ADD COLUMN event_message_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+-- system messages carry no content of their own, so the content check
+-- only applies to user messages
+-- +goose StatementBegin
+DO $$
+DECLARE
+  con_name TEXT;
+BEGIN
+  SELECT conname INTO con_name
+  FROM pg_constraint
+  WHERE conrelid = 'messages'::regclass AND contype = 'c'
+    AND pg_get_constraintdef(oid) LIKE '%(original_id IS NULL) AND%';
+
+  IF con_name IS NOT NULL THEN
+    EXECUTE format('ALTER TABLE messages DROP CONSTRAINT %I', con_name);
+  END IF;
+END;
+$$;
+-- +goose StatementEnd
+
+ALTER TABLE messages
+ADD CONSTRAINT message_content_check
+CHECK (
+  kind = 'user' AND event_type IS NULL AND (
+    original_id IS NULL AND (text IS NOT NULL OR media_url IS NOT NULL OR post_id IS NOT NULL)
+    OR text IS NULL AND media_url IS NULL)
+  OR kind = 'system' AND event_type IS NOT NULL
+    AND text IS NULL AND media_url IS NULL AND original_id IS NULL
+    AND response_to_id IS NULL AND post_id IS NULL
+);
+
+CREATE TABLE pinned_messages (
+  message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+  conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+  pinned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL
+);
+
+CREATE INDEX pinned_messages_conversation ON pinned_messages(conversation_id);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION unpin_deleted_message()
+RETURNS TRIGGER AS $$
+BEGIN
+  DELETE FROM pinned_messages WHERE message_id = NEW.id;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER unpin_deleted_message
+AFTER UPDATE OF is_deleted ON messages
+FOR EACH ROW
+WHEN (NEW.is_deleted = 1 AND OLD.is_deleted != 1)
+EXECUTE PROCEDURE unpin_deleted_message();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS unpin_deleted_message ON messages;
+DROP FUNCTION IF EXISTS unpin_deleted_message;
+DROP TABLE IF EXISTS pinned_messages;
+
+DELETE FROM messages WHERE kind = 'system';
+
+ALTER TABLE messages DROP CONSTRAINT message_content_check;
+
+ALTER TABLE messages
+ADD CHECK (
+  original_id IS NULL AND (text IS NOT NULL OR media_url IS NOT NULL OR post_id IS NOT NULL)
+  OR text IS NULL AND media_url IS NULL);
+
+ALTER TABLE messages
+DROP COLUMN kind,
+DROP COLUMN event_type,
+DROP COLUMN event_user_ids,
+DROP COLUMN event_message_id;
+
+DROP TYPE IF EXISTS message_kind;
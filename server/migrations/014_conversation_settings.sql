@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE participants
+ADD COLUMN muted_until TIMESTAMPTZ,
+ADD COLUMN is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN pinned_at TIMESTAMPTZ,
+ADD COLUMN marked_unread BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE participants
+DROP COLUMN muted_until,
+DROP COLUMN is_archived,
+DROP COLUMN pinned_at,
+DROP COLUMN marked_unread;
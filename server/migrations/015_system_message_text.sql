@@ -0,0 +1,30 @@
+-- +goose Up
+-- system messages may carry a short text such as the new conversation name
+ALTER TABLE messages DROP CONSTRAINT message_content_check;
+
+ALTER TABLE messages
+ADD CONSTRAINT message_content_check
+CHECK (
+  kind = 'user' AND event_type IS NULL AND (
+    original_id IS NULL AND (text IS NOT NULL OR media_url IS NOT NULL OR post_id IS NOT NULL)
+    OR text IS NULL AND media_url IS NULL)
+  OR kind = 'system' AND event_type IS NOT NULL
+    AND media_url IS NULL AND original_id IS NULL
+    AND response_to_id IS NULL AND post_id IS NULL
+);
+
+-- +goose Down
+UPDATE messages SET text = NULL WHERE kind = 'system';
+
+ALTER TABLE messages DROP CONSTRAINT message_content_check;
+
+ALTER TABLE messages
+ADD CONSTRAINT message_content_check
+CHECK (
+  kind = 'user' AND event_type IS NULL AND (
+    original_id IS NULL AND (text IS NOT NULL OR media_url IS NOT NULL OR post_id IS NOT NULL)
+    OR text IS NULL AND media_url IS NULL)
+  OR kind = 'system' AND event_type IS NOT NULL
+    AND text IS NULL AND media_url IS NULL AND original_id IS NULL
+    AND response_to_id IS NULL AND post_id IS NULL
+);
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE messages
+ADD COLUMN message_tsv tsvector GENERATED ALWAYS AS (to_tsvector('english', coalesce(text, ''))) STORED;
+
+CREATE INDEX message_tsv_idx ON messages USING GIN (message_tsv);
+
+-- +goose Down
+DROP INDEX IF EXISTS message_tsv_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS message_tsv;
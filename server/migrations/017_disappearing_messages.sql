@@ -0,0 +1,52 @@
+-- +goose Up
+ALTER TABLE conversations
+ADD COLUMN message_ttl INT CHECK (message_ttl IS NULL OR message_ttl > 0);
+
+ALTER TABLE messages ADD COLUMN expires_at TIMESTAMPTZ;
+
+CREATE INDEX message_expires_idx ON messages(expires_at) WHERE expires_at IS NOT NULL;
+
+-- replies outlive the expired message they respond to
+ALTER TABLE messages
+DROP CONSTRAINT messages_response_to_id_fkey,
+ADD CONSTRAINT messages_response_to_id_fkey
+  FOREIGN KEY (response_to_id) REFERENCES messages(id) ON DELETE SET NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_message_expiry()
+RETURNS TRIGGER AS $$
+DECLARE
+  ttl INT;
+BEGIN
+  IF NEW.kind = 'user' THEN
+    SELECT message_ttl INTO ttl
+    FROM conversations
+    WHERE id = NEW.conversation_id;
+
+    IF ttl IS NOT NULL THEN
+      NEW.expires_at = NEW.created_at + make_interval(secs => ttl);
+    END IF;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER set_message_expiry
+BEFORE INSERT ON messages
+FOR EACH ROW
+EXECUTE PROCEDURE set_message_expiry();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS set_message_expiry ON messages;
+DROP FUNCTION IF EXISTS set_message_expiry;
+
+ALTER TABLE messages
+DROP CONSTRAINT messages_response_to_id_fkey,
+ADD CONSTRAINT messages_response_to_id_fkey
+  FOREIGN KEY (response_to_id) REFERENCES messages(id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS message_expires_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE conversations DROP COLUMN IF EXISTS message_ttl;
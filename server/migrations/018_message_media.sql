@@ -0,0 +1,143 @@
+-- +goose Up
+CREATE TABLE message_media (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  updated_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  position SMALLINT NOT NULL DEFAULT 0,
+  url TEXT NOT NULL,
+  url_modifiers TEXT NOT NULL DEFAULT '',
+  type media_type NOT NULL,
+  mime VARCHAR(64) NOT NULL DEFAULT '',
+  subtype VARCHAR(32) NOT NULL DEFAULT '',
+  width SMALLINT NOT NULL DEFAULT 0,
+  height SMALLINT NOT NULL DEFAULT 0,
+  is_deleted BOOLEAN DEFAULT FALSE NOT NULL
+);
+
+CREATE INDEX message_media_idx ON message_media(message_id);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON message_media
+FOR EACH ROW
+EXECUTE PROCEDURE update_timestamp();
+
+INSERT INTO message_media (message_id, url, type, created_at)
+SELECT id, media_url, media_type, created_at
+FROM messages
+WHERE media_url IS NOT NULL AND media_type IS NOT NULL;
+
+ALTER TABLE messages ADD COLUMN has_media BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE messages SET has_media = TRUE WHERE media_url IS NOT NULL AND media_type IS NOT NULL;
+
+ALTER TABLE message_changes ADD COLUMN media JSONB;
+
+UPDATE message_changes
+SET media = jsonb_build_array(jsonb_build_object('url', media_url, 'type', media_type))
+WHERE media_url IS NOT NULL AND media_type IS NOT NULL;
+
+-- the media snapshot of a change is recorded by the application once the
+-- attachments of the message are written
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION on_message_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT'
+    OR NEW.text != OLD.text OR NEW.is_deleted != OLD.is_deleted
+  THEN
+    INSERT INTO message_changes (text, is_deleted, message_id)
+    VALUES (NEW.text, NEW.is_deleted, NEW.id);
+  END IF;
+
+  IF NEW.is_deleted != 1 THEN
+    NEW.updated_at = Now();
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+ALTER TABLE message_changes
+DROP COLUMN media_url,
+DROP COLUMN media_type;
+
+-- drops the content check along with the columns
+ALTER TABLE messages
+DROP COLUMN media_url,
+DROP COLUMN media_type;
+
+ALTER TABLE messages
+ADD CONSTRAINT message_content_check
+CHECK (
+  kind = 'user' AND event_type IS NULL AND (
+    original_id IS NULL AND (text IS NOT NULL OR has_media OR post_id IS NOT NULL)
+    OR text IS NULL AND NOT has_media)
+  OR kind = 'system' AND event_type IS NOT NULL
+    AND NOT has_media AND original_id IS NULL
+    AND response_to_id IS NULL AND post_id IS NULL
+);
+
+-- +goose Down
+ALTER TABLE messages DROP CONSTRAINT message_content_check;
+
+ALTER TABLE messages
+ADD COLUMN media_type media_type,
+ADD COLUMN media_url TEXT;
+
+UPDATE messages AS m SET media_url = mm.url, media_type = mm.type
+FROM (
+  SELECT DISTINCT ON (message_id) message_id, url, type
+  FROM message_media
+  WHERE is_deleted = FALSE
+  ORDER BY message_id, position
+) mm
+WHERE m.id = mm.message_id;
+
+ALTER TABLE messages ADD CHECK (NOT (media_url IS NOT NULL AND media_type IS NULL));
+
+ALTER TABLE messages
+ADD CONSTRAINT message_content_check
+CHECK (
+  kind = 'user' AND event_type IS NULL AND (
+    original_id IS NULL AND (text IS NOT NULL OR media_url IS NOT NULL OR post_id IS NOT NULL)
+    OR text IS NULL AND media_url IS NULL)
+  OR kind = 'system' AND event_type IS NOT NULL
+    AND media_url IS NULL AND original_id IS NULL
+    AND response_to_id IS NULL AND post_id IS NULL
+);
+
+ALTER TABLE messages DROP COLUMN has_media;
+
+ALTER TABLE message_changes
+ADD COLUMN media_url TEXT,
+ADD COLUMN media_type media_type;
+
+UPDATE message_changes
+SET media_url = media->0->>'url', media_type = (media->0->>'type')::media_type
+WHERE jsonb_array_length(media) > 0;
+
+ALTER TABLE message_changes DROP COLUMN media;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION on_message_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT'
+    OR NEW.text != OLD.text OR NEW.is_deleted != OLD.is_deleted
+    OR NEW.media_url != OLD.media_url 
+  THEN
+    INSERT INTO message_changes (text, media_url, media_type, is_deleted, message_id)
+    VALUES (NEW.text, NEW.media_url, NEW.media_type, NEW.is_deleted, NEW.id);
+  END IF;
+
+  IF NEW.is_deleted != 1 THEN
+    NEW.updated_at = Now();
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TABLE IF EXISTS message_media;
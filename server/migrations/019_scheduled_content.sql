@@ -0,0 +1,70 @@
+-- +goose Up
+ALTER TABLE posts
+ADD COLUMN scheduled_at TIMESTAMPTZ,
+ADD COLUMN schedule_error TEXT;
+
+CREATE INDEX post_scheduled_idx ON posts(user_id, scheduled_at) WHERE scheduled_at IS NOT NULL;
+
+ALTER TABLE messages
+ADD COLUMN scheduled_at TIMESTAMPTZ,
+ADD COLUMN schedule_error TEXT;
+
+CREATE INDEX message_scheduled_idx ON messages(user_id, scheduled_at) WHERE scheduled_at IS NOT NULL;
+
+-- scheduled messages get their expiry when they are published
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_message_expiry()
+RETURNS TRIGGER AS $$
+DECLARE
+  ttl INT;
+BEGIN
+  IF NEW.kind = 'user' AND NEW.scheduled_at IS NULL THEN
+    SELECT message_ttl INTO ttl
+    FROM conversations
+    WHERE id = NEW.conversation_id;
+
+    IF ttl IS NOT NULL THEN
+      NEW.expires_at = NEW.created_at + make_interval(secs => ttl);
+    END IF;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_message_expiry()
+RETURNS TRIGGER AS $$
+DECLARE
+  ttl INT;
+BEGIN
+  IF NEW.kind = 'user' THEN
+    SELECT message_ttl INTO ttl
+    FROM conversations
+    WHERE id = NEW.conversation_id;
+
+    IF ttl IS NOT NULL THEN
+      NEW.expires_at = NEW.created_at + make_interval(secs => ttl);
+    END IF;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DELETE FROM posts WHERE scheduled_at IS NOT NULL;
+DELETE FROM messages WHERE scheduled_at IS NOT NULL;
+
+DROP INDEX IF EXISTS post_scheduled_idx;
+DROP INDEX IF EXISTS message_scheduled_idx;
+
+ALTER TABLE posts
+DROP COLUMN scheduled_at,
+DROP COLUMN schedule_error;
+
+ALTER TABLE messages
+DROP COLUMN scheduled_at,
+DROP COLUMN schedule_error;
@@ -0,0 +1,30 @@
+-- +goose Up
+ALTER TABLE conversations ADD COLUMN updated_at TIMESTAMPTZ DEFAULT Now() NOT NULL;
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON conversations
+FOR EACH ROW
+EXECUTE PROCEDURE update_timestamp();
+
+-- membership, settings and read watermark changes all bump updated_at
+ALTER TABLE participants ADD COLUMN updated_at TIMESTAMPTZ DEFAULT Now() NOT NULL;
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON participants
+FOR EACH ROW
+EXECUTE PROCEDURE update_timestamp();
+
+CREATE INDEX participants_updated_idx ON participants(conversation_id, updated_at);
+CREATE INDEX message_updated_idx ON messages(conversation_id, updated_at);
+CREATE INDEX message_changes_created_idx ON message_changes(message_id, created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS message_changes_created_idx;
+DROP INDEX IF EXISTS message_updated_idx;
+DROP INDEX IF EXISTS participants_updated_idx;
+
+DROP TRIGGER IF EXISTS set_timestamp ON participants;
+ALTER TABLE participants DROP COLUMN updated_at;
+
+DROP TRIGGER IF EXISTS set_timestamp ON conversations;
+ALTER TABLE conversations DROP COLUMN updated_at;
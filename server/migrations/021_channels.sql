@@ -0,0 +1,104 @@
+-- +goose NO TRANSACTION
+
+-- +goose Up
+-- a new enum value cannot be used in the transaction that adds it
+ALTER TYPE conversation_type ADD VALUE IF NOT EXISTS 'channel';
+
+ALTER TABLE conversations ADD COLUMN handle VARCHAR(32);
+
+CREATE UNIQUE INDEX conversation_handle_idx ON conversations(lower(handle));
+
+ALTER TABLE conversations
+ADD CONSTRAINT channel_handle_check
+CHECK (handle IS NULL OR type = 'channel' AND handle ~ '^[A-Za-z0-9_]{4,32}$');
+
+ALTER TABLE conversations DROP CONSTRAINT private_conversation_check;
+
+ALTER TABLE conversations
+ADD CONSTRAINT private_conversation_check
+CHECK (type != 'private' OR avatar_url IS NULL);
+
+-- only owners and admins post to channels
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION check_message()
+RETURNS TRIGGER AS $$
+DECLARE
+  found_user UUID;
+  found_role participant_role;
+  conv_type conversation_type;
+  response_message messages%rowtype;
+BEGIN
+  SELECT user_id, role
+  FROM participants INTO found_user, found_role
+  WHERE user_id = NEW.user_id AND conversation_id = NEW.conversation_id;
+
+  IF found_user IS NULL THEN
+    RAISE EXCEPTION 'No such user in this conversation!';
+  END IF;
+
+  SELECT type FROM conversations INTO conv_type
+  WHERE id = NEW.conversation_id;
+
+  IF conv_type = 'channel' AND NEW.kind = 'user' AND found_role = 'member' THEN
+    RAISE EXCEPTION 'Only admins can post to the channel!';
+  END IF;
+
+  IF NEW.response_to_id IS NOT NULL THEN
+    SELECT * FROM messages INTO response_message
+    WHERE id = NEW.response_to_id;
+
+    IF response_message.conversation_id != NEW.conversation_id THEN
+      RAISE EXCEPTION 'Cannot response to the message from another conversation!';
+    END IF;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION check_message()
+RETURNS TRIGGER AS $$
+DECLARE
+  found_user UUID;
+  response_message messages%rowtype;
+BEGIN
+  SELECT user_id
+  FROM participants INTO found_user
+  WHERE user_id = NEW.user_id AND conversation_id = NEW.conversation_id;
+
+  IF found_user IS NULL THEN
+    RAISE EXCEPTION 'No such user in this conversation!';
+  END IF;
+
+  IF NEW.response_to_id IS NOT NULL THEN
+    SELECT * FROM messages INTO response_message
+    WHERE id = NEW.response_to_id;
+
+    IF response_message.conversation_id != NEW.conversation_id THEN
+      RAISE EXCEPTION 'Cannot response to the message from another conversation!';
+    END IF;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DELETE FROM conversations WHERE type = 'channel';
+
+ALTER TABLE conversations DROP CONSTRAINT private_conversation_check;
+
+ALTER TABLE conversations
+ADD CONSTRAINT private_conversation_check
+CHECK (type = 'group' OR avatar_url IS NULL);
+
+ALTER TABLE conversations DROP CONSTRAINT IF EXISTS channel_handle_check;
+
+DROP INDEX IF EXISTS conversation_handle_idx;
+
+ALTER TABLE conversations DROP COLUMN handle;
+
+-- enum values cannot be dropped, 'channel' stays unused in conversation_type
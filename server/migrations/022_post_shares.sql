@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX message_post_idx ON messages(post_id) WHERE post_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS message_post_idx;
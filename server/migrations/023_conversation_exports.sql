@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TYPE export_status AS ENUM ('pending', 'done', 'failed');
+
+CREATE TABLE conversation_exports (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  updated_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  status export_status NOT NULL DEFAULT 'pending',
+  token VARCHAR(64) NOT NULL UNIQUE,
+  json_data TEXT,
+  html_data TEXT,
+  error TEXT,
+  expires_at TIMESTAMPTZ,
+
+  CHECK (status != 'done' OR json_data IS NOT NULL AND html_data IS NOT NULL AND expires_at IS NOT NULL)
+);
+
+CREATE INDEX conversation_exports_user ON conversation_exports(user_id);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON conversation_exports
+FOR EACH ROW
+EXECUTE PROCEDURE update_timestamp();
+
+-- +goose Down
+DROP TABLE IF EXISTS conversation_exports;
+DROP TYPE IF EXISTS export_status;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TYPE message_privacy AS ENUM ('everyone', 'following', 'nobody');
+
+ALTER TABLE users ADD COLUMN message_privacy message_privacy NOT NULL DEFAULT 'everyone';
+
+ALTER TABLE participants ADD COLUMN is_request BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX participants_request_idx ON participants (user_id) WHERE is_request;
+
+-- +goose Down
+DROP INDEX IF EXISTS participants_request_idx;
+ALTER TABLE participants DROP COLUMN IF EXISTS is_request;
+ALTER TABLE users DROP COLUMN IF EXISTS message_privacy;
+DROP TYPE IF EXISTS message_privacy;
@@ -0,0 +1,38 @@
+-- +goose Up
+CREATE TABLE polls (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+  post_id UUID UNIQUE REFERENCES posts(id) ON DELETE CASCADE,
+  message_id UUID UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+  question VARCHAR(256) NOT NULL,
+  multiple BOOLEAN NOT NULL DEFAULT FALSE,
+  anonymous BOOLEAN NOT NULL DEFAULT TRUE,
+  closes_at TIMESTAMPTZ,
+
+  CONSTRAINT poll_owner_check CHECK ((post_id IS NULL) != (message_id IS NULL))
+);
+
+CREATE TABLE poll_options (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+  position SMALLINT NOT NULL,
+  text VARCHAR(100) NOT NULL,
+
+  UNIQUE (poll_id, position)
+);
+
+CREATE TABLE poll_votes (
+  poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+  option_id UUID NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ DEFAULT Now() NOT NULL,
+
+  PRIMARY KEY (option_id, user_id)
+);
+
+CREATE INDEX poll_votes_user_idx ON poll_votes (poll_id, user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS poll_votes;
+DROP TABLE IF EXISTS poll_options;
+DROP TABLE IF EXISTS polls;
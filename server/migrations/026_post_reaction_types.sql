@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE post_reactions ADD COLUMN type VARCHAR(32);
+
+UPDATE post_reactions SET type = CASE WHEN liked THEN 'like' ELSE 'dislike' END;
+
+ALTER TABLE post_reactions ALTER COLUMN type SET NOT NULL;
+ALTER TABLE post_reactions DROP COLUMN liked;
+
+CREATE INDEX post_reactions_type_idx ON post_reactions (post_id, type, created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS post_reactions_type_idx;
+
+ALTER TABLE post_reactions ADD COLUMN liked BOOLEAN;
+
+DELETE FROM post_reactions WHERE type NOT IN ('like', 'dislike');
+UPDATE post_reactions SET liked = type = 'like';
+
+ALTER TABLE post_reactions ALTER COLUMN liked SET NOT NULL;
+ALTER TABLE post_reactions DROP COLUMN type;
@@ -0,0 +1,40 @@
+-- +goose Up
+-- forwards outlive the expired message they point to, which is marked so
+-- that clients can show it as unavailable
+ALTER TABLE messages ADD COLUMN original_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE messages
+DROP CONSTRAINT messages_original_id_fkey,
+ADD CONSTRAINT messages_original_id_fkey
+  FOREIGN KEY (original_id) REFERENCES messages(id) ON DELETE SET NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION mark_original_deleted()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF OLD.original_id IS NOT NULL AND NEW.original_id IS NULL THEN
+    NEW.original_deleted = TRUE;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER mark_original_deleted
+BEFORE UPDATE OF original_id ON messages
+FOR EACH ROW
+EXECUTE PROCEDURE mark_original_deleted();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS mark_original_deleted ON messages;
+DROP FUNCTION IF EXISTS mark_original_deleted;
+
+DELETE FROM messages WHERE original_deleted;
+
+ALTER TABLE messages
+DROP CONSTRAINT messages_original_id_fkey,
+ADD CONSTRAINT messages_original_id_fkey
+  FOREIGN KEY (original_id) REFERENCES messages(id) ON DELETE CASCADE;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS original_deleted;
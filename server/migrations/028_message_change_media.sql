@@ -0,0 +1,56 @@
+-- +goose Up
+-- every change row snapshots the attachments the message has at that
+-- point, the application completes it when the attachments are replaced
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION on_message_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT'
+    OR NEW.text IS DISTINCT FROM OLD.text OR NEW.is_deleted != OLD.is_deleted
+  THEN
+    INSERT INTO message_changes (text, is_deleted, message_id, media)
+    VALUES (NEW.text, NEW.is_deleted, NEW.id, (
+      SELECT jsonb_agg(jsonb_build_object(
+        'id', mm.id,
+        'url', mm.url,
+        'urlModifiers', mm.url_modifiers,
+        'type', mm.type,
+        'mime', mm.mime,
+        'subtype', mm.subtype,
+        'width', mm.width,
+        'height', mm.height
+      ) ORDER BY mm.position)
+      FROM message_media AS mm
+      WHERE mm.message_id = NEW.id AND mm.is_deleted = FALSE
+    ));
+  END IF;
+
+  IF NEW.is_deleted != 1 THEN
+    NEW.updated_at = Now();
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION on_message_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT'
+    OR NEW.text != OLD.text OR NEW.is_deleted != OLD.is_deleted
+  THEN
+    INSERT INTO message_changes (text, is_deleted, message_id)
+    VALUES (NEW.text, NEW.is_deleted, NEW.id);
+  END IF;
+
+  IF NEW.is_deleted != 1 THEN
+    NEW.updated_at = Now();
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- tombstones of hard-deleted messages (expired or cancelled while
+-- scheduled) so that sync can tell clients to drop them. user_id is set
+-- for scheduled messages, which only their author has seen.
+CREATE TABLE deleted_messages (
+  message_id UUID PRIMARY KEY,
+  conversation_id UUID NOT NULL,
+  user_id UUID,
+  deleted_at TIMESTAMPTZ DEFAULT Now() NOT NULL
+);
+
+CREATE INDEX deleted_messages_conversation_idx ON deleted_messages(conversation_id, deleted_at);
+CREATE INDEX deleted_messages_deleted_at_idx ON deleted_messages(deleted_at);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_deleted_messages()
+RETURNS TRIGGER AS $$
+BEGIN
+  INSERT INTO deleted_messages (message_id, conversation_id, user_id)
+  SELECT id, conversation_id, CASE WHEN scheduled_at IS NOT NULL THEN user_id END
+  FROM old_messages
+  ON CONFLICT (message_id) DO NOTHING;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER record_deleted_messages
+AFTER DELETE ON messages
+REFERENCING OLD TABLE AS old_messages
+FOR EACH STATEMENT
+EXECUTE PROCEDURE record_deleted_messages();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS record_deleted_messages ON messages;
+DROP FUNCTION IF EXISTS record_deleted_messages;
+
+DROP TABLE IF EXISTS deleted_messages;
@@ -0,0 +1,25 @@
+-- +goose Up
+-- on_message_change runs after the update, so the updated_at it sets was
+-- never saved and edits did not reach sync. Deleting for everyone is
+-- still left out, sync finds those through message_changes.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_message_updated_at()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW.is_deleted != 1 THEN
+    NEW.updated_at = Now();
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER set_message_updated_at
+BEFORE UPDATE ON messages
+FOR EACH ROW
+EXECUTE PROCEDURE set_message_updated_at();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS set_message_updated_at ON messages;
+DROP FUNCTION IF EXISTS set_message_updated_at;
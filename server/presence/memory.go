@@ -0,0 +1,114 @@
+package presence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type MemoryStore struct {
+	mu          sync.Mutex
+	connections map[string]int
+	lastSeen    map[string]time.Time
+	typing      map[string]map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		connections: make(map[string]int),
+		lastSeen:    make(map[string]time.Time),
+		typing:      make(map[string]map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Connect(ctx context.Context, userId string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connections[userId]++
+	return s.connections[userId] == 1, nil
+}
+
+func (s *MemoryStore) Disconnect(ctx context.Context, userId string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.connections[userId] == 0 {
+		return false, nil
+	}
+
+	s.connections[userId]--
+	if s.connections[userId] != 0 {
+		return false, nil
+	}
+
+	delete(s.connections, userId)
+	s.lastSeen[userId] = time.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) Statuses(ctx context.Context, userIds []string) (map[string]Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]Status, len(userIds))
+	for _, id := range userIds {
+		status := Status{Online: s.connections[id] != 0}
+		if seen, ok := s.lastSeen[id]; ok && !status.Online {
+			status.LastSeen = &seen
+		}
+		result[id] = status
+	}
+
+	return result, nil
+}
+
+func (s *MemoryStore) SetTyping(ctx context.Context, convId, userId string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, ok := s.typing[convId]
+	if !ok {
+		users = make(map[string]time.Time)
+		s.typing[convId] = users
+	}
+	users[userId] = time.Now().Add(ttl)
+
+	return nil
+}
+
+func (s *MemoryStore) ClearTyping(ctx context.Context, convId, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if users, ok := s.typing[convId]; ok {
+		delete(users, userId)
+		if len(users) == 0 {
+			delete(s.typing, convId)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Typing(ctx context.Context, convId string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	result := make([]string, 0)
+
+	for userId, until := range s.typing[convId] {
+		if until.Before(now) {
+			delete(s.typing[convId], userId)
+			continue
+		}
+		result = append(result, userId)
+	}
+
+	if len(s.typing[convId]) == 0 {
+		delete(s.typing, convId)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,27 @@
+package presence
+
+import (
+	"context"
+	"time"
+)
+
+type Status struct {
+	Online   bool
+	LastSeen *time.Time
+}
+
+// Store keeps track of connected users and typing indicators. The state is
+// ephemeral, so an implementation shared between server instances only
+// needs to provide expiring keys and counters.
+type Store interface {
+	// Connect registers a new connection of the user and reports whether
+	// the user has just come online.
+	Connect(ctx context.Context, userId string) (bool, error)
+	// Disconnect removes a connection of the user and reports whether it
+	// was the last one.
+	Disconnect(ctx context.Context, userId string) (bool, error)
+	Statuses(ctx context.Context, userIds []string) (map[string]Status, error)
+	SetTyping(ctx context.Context, convId, userId string, ttl time.Duration) error
+	ClearTyping(ctx context.Context, convId, userId string) error
+	Typing(ctx context.Context, convId string) ([]string, error)
+}
@@ -0,0 +1,97 @@
+package realtime
+
+import "sync"
+
+const sendBuffer = 64
+
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+type Client struct {
+	UserId string
+	send   chan Event
+	once   sync.Once
+}
+
+func (c *Client) Events() <-chan Event {
+	return c.send
+}
+
+func (c *Client) close() {
+	c.once.Do(func() {
+		close(c.send)
+	})
+}
+
+type hub struct {
+	mu      sync.RWMutex
+	clients map[string]map[*Client]struct{}
+	closed  bool
+}
+
+var h = hub{clients: make(map[string]map[*Client]struct{})}
+
+// Register adds a connection of the user to the hub. It returns nil when
+// the hub has been shut down.
+func Register(userId string) *Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
+	c := &Client{UserId: userId, send: make(chan Event, sendBuffer)}
+	if _, ok := h.clients[userId]; !ok {
+		h.clients[userId] = make(map[*Client]struct{})
+	}
+	h.clients[userId][c] = struct{}{}
+
+	return c
+}
+
+func Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if clients, ok := h.clients[c.UserId]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.clients, c.UserId)
+		}
+	}
+	c.close()
+}
+
+// Publish delivers the event to every local connection of the given users.
+// Slow connections whose buffer is full miss the event instead of blocking
+// the publisher.
+func Publish(userIds []string, e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, id := range userIds {
+		for c := range h.clients[id] {
+			select {
+			case c.send <- e:
+			default:
+			}
+		}
+	}
+}
+
+// Shutdown closes every connection and rejects new ones.
+func Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, clients := range h.clients {
+		for c := range clients {
+			c.close()
+		}
+	}
+	h.clients = make(map[string]map[*Client]struct{})
+}
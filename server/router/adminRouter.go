@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addAdminRouter(app *fiber.App) {
+	admin := app.Group("admin", middleware.RequireAdmin)
+
+	admin.Get("/log-level", handlers.GetLogLevel)
+	admin.Put("/log-level", handlers.SetLogLevel)
+}
@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addChannelRouter(app *fiber.App) {
+	channel := app.Group("channel")
+
+	channel.Get("/:handle", middleware.RequireAuth, handlers.GetChannelPreview)
+	channel.Post("/:handle/join", middleware.RequireAuth, handlers.JoinChannel)
+}
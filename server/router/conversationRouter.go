@@ -11,11 +11,25 @@ func addConversationRouter(app *fiber.App) {
 
 	conversation.Get("/", middleware.RequireAuth, handlers.GetConversations)
 	conversation.Post("/", middleware.RequireAuth, handlers.CreateConversation)
+	conversation.Get("/requests", middleware.RequireAuth, handlers.GetMessageRequests)
+	conversation.Post("/:id/accept", middleware.RequireAuth, handlers.AcceptMessageRequest)
+	conversation.Post("/:id/decline", middleware.RequireAuth, handlers.DeclineMessageRequest)
 	conversation.Post("/:id/add", middleware.RequireAuth, handlers.AddUsersToConversation)
 	conversation.Post("/:id/kick", middleware.RequireAuth, handlers.KickUser)
+	conversation.Post("/:id/promote", middleware.RequireAuth, handlers.PromoteUser)
+	conversation.Post("/:id/demote", middleware.RequireAuth, handlers.DemoteUser)
+	conversation.Post("/:id/invites", middleware.RequireAuth, handlers.CreateInvite)
+	conversation.Get("/:id/invites", middleware.RequireAuth, handlers.GetInvites)
+	conversation.Delete("/:id/invites/:inviteId", middleware.RequireAuth, handlers.RevokeInvite)
+	conversation.Post("/:id/pins/:messageId", middleware.RequireAuth, handlers.PinMessage)
+	conversation.Delete("/:id/pins/:messageId", middleware.RequireAuth, handlers.UnpinMessage)
+	conversation.Patch("/:id/settings", middleware.RequireAuth, handlers.UpdateConversationSettings)
+	conversation.Post("/:id/export", middleware.RequireAuth, handlers.CreateConversationExport)
 	conversation.Post("/:id/leave", middleware.RequireAuth, handlers.LeaveConversation)
-	conversation.Post("/:id/join", middleware.RequireAuth, handlers.JoinConversation)
 	conversation.Get("/:id/messages", middleware.RequireAuth, handlers.GetMessages)
+	conversation.Get("/:id/search", middleware.RequireAuth, handlers.SearchConversation)
+	conversation.Post("/:id/read", middleware.RequireAuth, handlers.ReadConversation)
+	conversation.Post("/:id/typing", middleware.RequireAuth, handlers.SetTyping)
 	conversation.Get("/:id", middleware.RequireAuth, handlers.GetConversationInfo)
 	conversation.Patch("/:id", middleware.RequireAuth, handlers.EditConversation)
 	conversation.Delete("/:id", middleware.RequireAuth, handlers.DeleteConversation)
@@ -0,0 +1,15 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addExportRouter(app *fiber.App) {
+	export := app.Group("export")
+
+	// the token itself grants access, so the link works outside the app
+	export.Get("/download/:token", handlers.DownloadExport)
+	export.Get("/:id", middleware.RequireAuth, handlers.GetConversationExport)
+}
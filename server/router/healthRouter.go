@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addHealthRouter(app *fiber.App) {
+	app.Get("/healthz", handlers.Healthz)
+	app.Get("/readyz", handlers.Readyz)
+	// scraped with the admin token, like the other operational endpoints
+	app.Get("/metrics", middleware.RequireAdmin, handlers.GetMetrics)
+}
@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addInviteRouter(app *fiber.App) {
+	invite := app.Group("invite")
+
+	invite.Get("/:token", middleware.RequireAuth, handlers.GetInvitePreview)
+	invite.Post("/:token/join", middleware.RequireAuth, handlers.JoinByInvite)
+}
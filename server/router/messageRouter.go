@@ -10,7 +10,12 @@ func addMessageRouter(app *fiber.App) {
 	message := app.Group("message")
 
 	message.Post("/", middleware.RequireAuth, handlers.CreateMessage)
+	message.Get("/search", middleware.RequireAuth, handlers.SearchMessages)
 	message.Patch("/:id", middleware.RequireAuth, handlers.EditMessage)
 	message.Delete("/:id", middleware.RequireAuth, handlers.DeleteMessage)
+	message.Get("/:id/context", middleware.RequireAuth, handlers.GetMessageContext)
 	message.Get("/:id/changes", middleware.RequireAuth, handlers.GetMessageChanges)
+	message.Get("/:id/seen", middleware.RequireAuth, handlers.GetMessageSeen)
+	message.Post("/:id/reactions", middleware.RequireAuth, handlers.ToggleMessageReaction)
+	message.Delete("/:id/reactions", middleware.RequireAuth, handlers.RemoveMessageReaction)
 }
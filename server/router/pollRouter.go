@@ -0,0 +1,15 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addPollRouter(app *fiber.App) {
+	poll := app.Group("poll")
+
+	poll.Post("/:id/vote", middleware.RequireAuth, handlers.Vote)
+	poll.Delete("/:id/vote", middleware.RequireAuth, handlers.RetractVote)
+	poll.Get("/:id/voters", middleware.RequireAuth, handlers.GetPollVoters)
+}
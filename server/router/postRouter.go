@@ -13,10 +13,15 @@ func addPostRouter(app *fiber.App) {
 	post.Post("/", middleware.RequireAuth, handlers.CreatePost)
 	post.Patch("/:id", middleware.RequireAuth, handlers.UpdatePost)
 	post.Post("/reaction", middleware.RequireAuth, handlers.ProcessReaction)
+	post.Get("/reactions", handlers.GetPostReactionTypes)
 	post.Get("/favorite", middleware.RequireAuth, handlers.GetFavoritePosts)
 	post.Get("/search", middleware.ParseAuth, handlers.GetPostsBySearch)
 	post.Get("/:id", middleware.ParseAuth, handlers.GetPostById)
 	post.Post("/favorite", middleware.RequireAuth, handlers.ProcessFavorite)
 	post.Delete("/:id", middleware.RequireAuth, handlers.DeletePost)
 	post.Get("/:id/history", middleware.RequireAuth, handlers.GetPostHistory)
+	post.Post("/:id/share", middleware.RequireAuth, handlers.SharePost)
+	post.Post("/:id/reaction", middleware.RequireAuth, handlers.TogglePostReaction)
+	post.Delete("/:id/reaction", middleware.RequireAuth, handlers.RemovePostReaction)
+	post.Get("/:id/reactions", middleware.ParseAuth, handlers.GetPostReactions)
 }
@@ -0,0 +1,11 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addRealtimeRouter(app *fiber.App) {
+	app.Get("/ws", middleware.RequireSocketAuth, handlers.Realtime)
+}
@@ -3,11 +3,20 @@ package router
 import "github.com/gofiber/fiber/v2"
 
 func SetupRouter(app *fiber.App) {
+	addHealthRouter(app)
+	addAdminRouter(app)
 	addAuthRouter(app)
 	addPostRouter(app)
 	addCommentRouter(app)
 	addUserRouter(app)
 	addTagRouter(app)
 	addConversationRouter(app)
+	addInviteRouter(app)
+	addChannelRouter(app)
 	addMessageRouter(app)
+	addPollRouter(app)
+	addScheduleRouter(app)
+	addSyncRouter(app)
+	addExportRouter(app)
+	addRealtimeRouter(app)
 }
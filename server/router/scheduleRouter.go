@@ -0,0 +1,17 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addScheduleRouter(app *fiber.App) {
+	scheduled := app.Group("scheduled")
+
+	scheduled.Get("/", middleware.RequireAuth, handlers.GetScheduled)
+	scheduled.Patch("/posts/:id", middleware.RequireAuth, handlers.ReschedulePost)
+	scheduled.Delete("/posts/:id", middleware.RequireAuth, handlers.CancelScheduledPost)
+	scheduled.Patch("/messages/:id", middleware.RequireAuth, handlers.RescheduleMessage)
+	scheduled.Delete("/messages/:id", middleware.RequireAuth, handlers.CancelScheduledMessage)
+}
@@ -0,0 +1,11 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/crickter/handlers"
+	"github.com/yura4ka/crickter/middleware"
+)
+
+func addSyncRouter(app *fiber.App) {
+	app.Get("/sync", middleware.RequireAuth, handlers.Sync)
+}
@@ -85,6 +85,9 @@ func clean(i interface{}) {
 		c.SqlClean()
 	}
 	v := reflect.ValueOf(i).Elem()
+	if v.Kind() != reflect.Struct {
+		return
+	}
 
 	for i := 0; i < v.NumField(); i++ {
 		f := v.Field(i)
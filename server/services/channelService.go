@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+var handleRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{4,32}$`)
+
+// checkHandle makes sure the handle is well-formed and not taken by a
+// conversation other than convId, which is empty for new channels.
+func checkHandle(ctx context.Context, handle, convId string) error {
+	if !handleRegexp.MatchString(handle) {
+		return ErrWrongData
+	}
+
+	var exists bool
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM conversations
+			WHERE lower(handle) = lower($1) AND id IS DISTINCT FROM $2
+		);
+	`, handle, ToNullString(&convId)).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrAlreadyExists
+	}
+
+	return nil
+}
+
+func GetChannelPreview(ctx context.Context, handle, userId string) (*InvitePreview, error) {
+	ctx, span := tracing.Start(ctx, "services.GetChannelPreview")
+	defer span.End()
+
+	var p InvitePreview
+	row := db.Client.QueryRowContext(ctx, `
+		SELECT c.id, c.name, c.avatar_url, c.avatar_type,
+			(
+				SELECT COUNT(*)
+				FROM participants AS p
+				WHERE p.conversation_id = c.id AND p.has_left = false AND p.is_kicked = false
+			),
+			EXISTS (
+				SELECT 1
+				FROM participants AS p
+				WHERE p.conversation_id = c.id AND p.user_id = $2 AND p.has_left = false AND p.is_kicked = false
+			)
+		FROM conversations AS c
+		WHERE lower(c.handle) = lower($1) AND c.type = 'channel' AND c.is_deleted != 1;
+	`, handle, userId)
+
+	err := scanner.Scan(row, &p)
+	if err == sql.ErrNoRows {
+		return nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// JoinChannel subscribes userId to the public channel with the handle and
+// returns its id.
+func JoinChannel(ctx context.Context, handle, userId string) (string, error) {
+	ctx, span := tracing.Start(ctx, "services.JoinChannel")
+	defer span.End()
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var convId string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id
+		FROM conversations
+		WHERE lower(handle) = lower($1) AND type = 'channel' AND is_deleted != 1;
+	`, handle).Scan(&convId)
+	if err == sql.ErrNoRows {
+		return "", ErrChannelNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = joinConversation(ctx, tx, convId, "channel", userId)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return convId, nil
+}
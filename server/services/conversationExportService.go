@@ -0,0 +1,399 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/jobs"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/realtime"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	exportConversationKind = "conversations.export"
+	cleanupExportsKind     = "conversations.exports.cleanup"
+	cleanupExportsInterval = time.Hour
+	exportTokenBytes       = 24
+	// exportLinkAge is how long a finished export can be downloaded.
+	exportLinkAge = 24 * time.Hour
+	// exportRetention is how long unfinished exports are kept around.
+	exportRetention = 7 * 24 * time.Hour
+)
+
+const (
+	ExportPending = "pending"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+type ConversationExport struct {
+	Id             string  `json:"id"`
+	CreatedAt      string  `json:"createdAt"`
+	ConversationId string  `json:"conversationId"`
+	Status         string  `json:"status"`
+	Token          *string `json:"token,omitempty"`
+	Error          *string `json:"error,omitempty"`
+	ExpiresAt      *string `json:"expiresAt,omitempty"`
+}
+
+func (e *ConversationExport) SqlClean() {
+	// the token is the download link, so it is only handed out once ready
+	if e.Status != ExportDone {
+		e.Token = nil
+	}
+}
+
+type exportPayload struct {
+	Id string `json:"id"`
+}
+
+type cleanupExportsPayload struct{}
+
+func registerConversationExports() {
+	jobs.Register(exportConversationKind, func(ctx context.Context, p exportPayload) error {
+		return RunConversationExport(ctx, p.Id)
+	})
+
+	jobs.Register(cleanupExportsKind, func(ctx context.Context, _ cleanupExportsPayload) error {
+		_, err := db.Client.ExecContext(ctx, `
+			DELETE FROM conversation_exports
+			WHERE expires_at <= Now() OR status != 'done' AND created_at < $1;
+		`, time.Now().Add(-exportRetention))
+		return err
+	})
+	jobs.Schedule(cleanupExportsKind, cleanupExportsInterval, cleanupExportsPayload{})
+}
+
+// CreateConversationExport queues rendering of the conversation history
+// as seen by userId. The export is polled with GetConversationExport.
+func CreateConversationExport(ctx context.Context, convId, userId string) (*ConversationExport, error) {
+	ctx, span := tracing.Start(ctx, "services.CreateConversationExport")
+	defer span.End()
+
+	if !isParticipant(ctx, convId, userId) {
+		return nil, ErrForbidden
+	}
+
+	token, err := newRandomToken(exportTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO conversation_exports (conversation_id, user_id, token)
+		VALUES ($1, $2, $3)
+		RETURNING id;
+	`, convId, userId, token).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = jobs.EnqueueTx(ctx, tx, exportConversationKind, exportPayload{Id: id})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetConversationExport(ctx, id, userId)
+}
+
+func GetConversationExport(ctx context.Context, exportId, userId string) (*ConversationExport, error) {
+	ctx, span := tracing.Start(ctx, "services.GetConversationExport")
+	defer span.End()
+
+	var e ConversationExport
+	row := db.Client.QueryRowContext(ctx, `
+		SELECT id, created_at, conversation_id, status, token, error, expires_at
+		FROM conversation_exports
+		WHERE id = $1 AND user_id = $2;
+	`, exportId, userId)
+
+	err := scanner.Scan(row, &e)
+	if err == sql.ErrNoRows {
+		return nil, ErrForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// GetExportFile returns the rendered export behind a download token in
+// the requested format, "json" or "html".
+func GetExportFile(ctx context.Context, token, format string) (string, error) {
+	ctx, span := tracing.Start(ctx, "services.GetExportFile")
+	defer span.End()
+
+	column := "json_data"
+	switch format {
+	case "json":
+	case "html":
+		column = "html_data"
+	default:
+		return "", ErrWrongData
+	}
+
+	var data string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT `+column+`
+		FROM conversation_exports
+		WHERE token = $1 AND status = 'done' AND expires_at > Now();
+	`, token).Scan(&data)
+	if err == sql.ErrNoRows {
+		return "", ErrExportNotFound
+	}
+
+	return data, err
+}
+
+type exportDocument struct {
+	Conversation exportConversation `json:"conversation"`
+	ExportedAt   time.Time          `json:"exportedAt"`
+	Participants []MessageUser      `json:"participants"`
+	Messages     []exportMessage    `json:"messages"`
+}
+
+type exportConversation struct {
+	Id       string  `json:"id"`
+	ConvType string  `json:"type"`
+	Name     *string `json:"name,omitempty"`
+}
+
+type exportMessage struct {
+	Message
+	Changes []MessageChange `json:"changes,omitempty"`
+}
+
+// RunConversationExport renders a pending export. Exporting fails for
+// good once the user is no longer a participant, other errors are retried
+// and mark the export as failed when the last attempt runs out.
+func RunConversationExport(ctx context.Context, exportId string) error {
+	ctx, span := tracing.Start(ctx, "services.RunConversationExport")
+	defer span.End()
+
+	var convId, userId string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT conversation_id, user_id
+		FROM conversation_exports
+		WHERE id = $1 AND status = 'pending';
+	`, exportId).Scan(&convId, &userId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !isParticipant(ctx, convId, userId) {
+		return failExport(ctx, exportId, ErrForbidden)
+	}
+
+	err = renderExport(ctx, exportId, convId, userId)
+	if err != nil {
+		if jobs.LastAttempt(ctx) {
+			// the attempt may have run out of time, the status is saved anyway
+			if failErr := failExport(context.WithoutCancel(ctx), exportId, ErrExportFailed); failErr != nil {
+				return failErr
+			}
+		}
+		return err
+	}
+
+	e, err := GetConversationExport(ctx, exportId, userId)
+	if err == nil {
+		realtime.Publish([]string{userId}, realtime.Event{Type: "export_ready", Data: e})
+	}
+	return nil
+}
+
+func renderExport(ctx context.Context, exportId, convId, userId string) error {
+	doc, err := buildExportDocument(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var html bytes.Buffer
+	err = exportTemplate.Execute(&html, doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Client.ExecContext(ctx, `
+		UPDATE conversation_exports
+		SET status = 'done', json_data = $1, html_data = $2, expires_at = $3
+		WHERE id = $4;
+	`, string(jsonData), html.String(), time.Now().Add(exportLinkAge), exportId)
+	return err
+}
+
+func failExport(ctx context.Context, exportId string, reason error) error {
+	logging.FromContext(ctx).Info("conversation export failed",
+		slog.String("id", exportId), slog.String("reason", reason.Error()))
+
+	_, err := db.Client.ExecContext(ctx, `
+		UPDATE conversation_exports SET status = 'failed', error = $1
+		WHERE id = $2;
+	`, reason.Error(), exportId)
+	return err
+}
+
+func buildExportDocument(ctx context.Context, convId, userId string) (*exportDocument, error) {
+	doc := exportDocument{ExportedAt: time.Now()}
+
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT id, type, name
+		FROM conversations
+		WHERE id = $1;
+	`, convId).Scan(&doc.Conversation.Id, &doc.Conversation.ConvType, &doc.Conversation.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Participants, err = getExportParticipants(ctx, convId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := getMessages(ctx, userId, messageQuery{
+		where:     "m.conversation_id = $2",
+		args:      []any{convId},
+		ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := getOwnMessageChanges(ctx, convId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Messages = make([]exportMessage, 0, len(messages))
+	for _, m := range messages {
+		doc.Messages = append(doc.Messages, exportMessage{Message: m, Changes: changes[m.Id]})
+	}
+
+	return &doc, nil
+}
+
+// getExportParticipants lists the members of the conversation. Like the
+// conversation info, channels only show their owner and admins and the
+// exporting user.
+func getExportParticipants(ctx context.Context, convId, userId string) ([]MessageUser, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted, p.role
+		FROM participants AS p
+		INNER JOIN conversations AS c ON p.conversation_id = c.id
+		INNER JOIN users AS u ON p.user_id = u.id
+		WHERE p.conversation_id = $1 AND p.has_left = false AND p.is_kicked = false
+			AND (c.type != 'channel' OR p.role != 'member' OR p.user_id = $2)
+		ORDER BY p.created_at;
+	`, convId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]MessageUser, 0)
+	for rows.Next() {
+		var u MessageUser
+		var role string
+		if err := scanner.Scan(rows, &u, &role); err != nil {
+			return nil, err
+		}
+		u.Role = &role
+		result = append(result, u)
+	}
+
+	return result, rows.Err()
+}
+
+// getOwnMessageChanges returns the edit history of the messages userId
+// sent to the conversation, keyed by message id.
+func getOwnMessageChanges(ctx context.Context, convId, userId string) (map[string][]MessageChange, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT m.id, c.id, c.created_at, c.text, c.is_deleted, c.media
+		FROM messages AS m
+		INNER JOIN message_changes AS c ON m.id = c.message_id
+		WHERE m.conversation_id = $1 AND m.user_id = $2 AND m.kind = 'user'
+		ORDER BY c.created_at;
+	`, convId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]MessageChange)
+	for rows.Next() {
+		var messageId string
+		var c MessageChange
+		if err := scanner.Scan(rows, &messageId, &c); err != nil {
+			return nil, err
+		}
+		result[messageId] = append(result[messageId], c)
+	}
+
+	return result, rows.Err()
+}
+
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{with .Conversation.Name}}{{.}}{{else}}Conversation{{end}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; }
+.message { margin: 0.75rem 0; }
+.meta { color: #666; font-size: 0.85rem; }
+.system { color: #666; font-style: italic; }
+.changes { color: #666; font-size: 0.85rem; margin-left: 1rem; }
+</style>
+</head>
+<body>
+<h1>{{with .Conversation.Name}}{{.}}{{else}}Conversation{{end}}</h1>
+<p class="meta">Exported at {{.ExportedAt.Format "2006-01-02 15:04 MST"}}</p>
+<h2>Participants</h2>
+<ul>
+{{range .Participants}}<li>{{with .Name}}{{.}}{{end}}{{with .Username}} @{{.}}{{end}}{{with .Role}} ({{.}}){{end}}</li>
+{{end}}</ul>
+<h2>Messages</h2>
+{{range .Messages}}<div class="message{{if eq .Kind "system"}} system{{end}}">
+<div class="meta">{{with .User}}{{with .Name}}{{.}}{{end}}{{end}} · {{.CreatedAt}}{{if .UpdatedAt}} · edited{{end}}</div>
+{{if .Event}}<div>{{.Event.Type}}{{range .Event.Users}} {{with .Name}}{{.}}{{end}}{{end}}{{with .Text}}: {{.}}{{end}}</div>
+{{else}}{{with .Text}}<div>{{.}}</div>{{end}}
+{{with .PostId}}<div>Shared post {{.}}</div>{{end}}
+{{if .OriginalDeleted}}<div>Forwarded message unavailable</div>{{end}}
+{{range .Media}}<div><a href="{{.Url}}">{{.Type}}</a></div>
+{{end}}{{end}}{{with .Changes}}<details class="changes"><summary>Edit history</summary>
+{{range .}}<div>{{.CreatedAt}}: {{with .Text}}{{.}}{{else}}(no text){{end}}</div>
+{{end}}</details>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
@@ -1,21 +1,65 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/metrics"
 	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
 )
 
 type CreateConversationRequest struct {
-	ConvType  string `json:"type"`
-	Name      string `json:"name"`
-	AddUserId string `json:"addUserId"`
+	ConvType  string  `json:"type"`
+	Name      string  `json:"name"`
+	AddUserId string  `json:"addUserId"`
+	Handle    *string `json:"handle"`
 }
 
-func CreateConversation(creatorId string, params *CreateConversationRequest) (string, error) {
+func CreateConversation(ctx context.Context, creatorId string, params *CreateConversationRequest) (string, error) {
+	ctx, span := tracing.Start(ctx, "services.CreateConversation")
+	defer span.End()
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	id, err := createConversation(ctx, tx, creatorId, params)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// findPrivateConversation returns the id of the private conversation
+// between the two users or sql.ErrNoRows when they have none. Deleted
+// conversations don't count, so a new one can be started.
+func findPrivateConversation(ctx context.Context, userId, otherId string) (string, error) {
+	var id string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT c.id
+		FROM conversations AS c
+		INNER JOIN participants AS p1 ON c.id = p1.conversation_id AND p1.user_id = $1
+		INNER JOIN participants AS p2 ON c.id = p2.conversation_id AND p2.user_id = $2
+		WHERE c.type = 'private' AND c.is_deleted != 1
+		LIMIT 1;
+	`, userId, otherId).Scan(&id)
+	return id, err
+}
+
+func createConversation(ctx context.Context, tx *sql.Tx, creatorId string, params *CreateConversationRequest) (string, error) {
 	if creatorId == params.AddUserId {
 		return "", ErrAlreadyExists
 	}
@@ -23,7 +67,7 @@ func CreateConversation(creatorId string, params *CreateConversationRequest) (st
 	params.Name = strings.TrimSpace(params.Name)
 	if params.ConvType == "private" {
 		params.Name = ""
-		isBlocked, err := IsUserBlocked(params.AddUserId, creatorId)
+		isBlocked, err := IsUserBlocked(ctx, params.AddUserId, creatorId)
 		if err != nil {
 			return "", err
 		}
@@ -34,78 +78,86 @@ func CreateConversation(creatorId string, params *CreateConversationRequest) (st
 		return "", ErrEmptyString
 	}
 
-	if params.ConvType == "private" {
-		var count int
-		err := db.Client.QueryRow(`
-			SELECT COUNT(c.*)
-			FROM conversations AS c
-			INNER JOIN participants AS p1 ON c.id = p1.conversation_id AND p1.user_id = $1
-			INNER JOIN participants AS p2 ON c.id = p2.conversation_id AND p2.user_id = $2
-			WHERE c.type = 'private'
-		`, creatorId, params.AddUserId).Scan(&count)
+	if params.ConvType != "channel" {
+		params.Handle = nil
+	} else if params.Handle != nil {
+		err := checkHandle(ctx, *params.Handle, "")
 		if err != nil {
 			return "", err
 		}
-		if count != 0 {
+	}
+
+	if params.ConvType == "private" {
+		_, err := findPrivateConversation(ctx, creatorId, params.AddUserId)
+		if err == nil {
 			return "", ErrAlreadyExists
 		}
+		if err != sql.ErrNoRows {
+			return "", err
+		}
 	}
 
 	var id string
-
-	tx, err := db.Client.Begin()
-	if err != nil {
-		return "", err
-	}
-	defer tx.Rollback()
-
-	err = tx.QueryRow(`
-		INSERT INTO conversations (creator_id, type, name)
-		VALUES ($1, $2, $3)
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO conversations (creator_id, type, name, handle)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id;
-	`, creatorId, params.ConvType, ToNullString(&params.Name)).Scan(&id)
+	`, creatorId, params.ConvType, ToNullString(&params.Name), params.Handle).Scan(&id)
 	if err != nil {
 		return "", err
 	}
 
-	query := "VALUES ($1, $2)"
+	query := "VALUES ($1, $2, $3, false)"
 	args := make([]any, 0)
 	args = append(args, id, creatorId)
 
 	if params.ConvType == "private" {
-		query += ", ($1, $3)"
-		args = append(args, params.AddUserId)
+		requests, err := getRequestMap(ctx, creatorId, []string{params.AddUserId})
+		if err != nil {
+			return "", err
+		}
+		query += ", ($1, $4, $3, $5)"
+		args = append(args, RoleMember, params.AddUserId, requests[params.AddUserId])
+	} else {
+		args = append(args, RoleOwner)
 	}
 
-	_, err = tx.Exec(`
-		INSERT INTO participants (conversation_id, user_id)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO participants (conversation_id, user_id, role, is_request)
 	`+query, args...)
 	if err != nil {
 		return "", err
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return "", err
-	}
+	return id, nil
+}
 
-	return id, err
+func GetConversations(ctx context.Context, userId string, archived bool) ([]Conversation, error) {
+	ctx, span := tracing.Start(ctx, "services.GetConversations")
+	defer span.End()
+	defer metrics.TimeQuery("get_conversations")()
+
+	return getConversations(ctx, userId, "o.is_archived = $2 AND o.is_request = false", archived)
 }
 
-func GetConversations(userId string) ([]Conversation, error) {
-	rows, err := db.Client.Query(`
+// getConversations lists the conversations of userId matching the where
+// condition, which can refer to the conversation c, the participant row o
+// of userId and the last message lm. Its args are numbered from $2.
+func getConversations(ctx context.Context, userId, where string, args ...any) ([]Conversation, error) {
+	rows, err := db.Client.QueryContext(ctx, `
 		SELECT c.id, c.type, c.name,
 			u.*,
-			CASE
-				WHEN COUNT(m.*) = 0 THEN 0 
-			ELSE
-				SUM(CASE WHEN mr.message_id IS NULL AND m.is_deleted != 1 THEN 1 ELSE 0 END)
-			END AS unread,
+			(
+				SELECT COUNT(*)
+				FROM messages AS m
+				WHERE m.conversation_id = c.id AND m.user_id != $1 AND m.is_deleted != 1 AND `+messageNotExpired+`
+					AND m.scheduled_at IS NULL AND (o.last_read_at IS NULL OR m.created_at > o.last_read_at)
+			) AS unread,
+			CASE WHEN o.muted_until > Now() THEN o.muted_until END AS muted_until,
+			o.is_archived, o.pinned_at IS NOT NULL AS is_pinned, o.marked_unread, o.is_request,
 			lm.*
 		FROM conversations AS c
 		INNER JOIN participants AS o ON c.id = o.conversation_id AND o.user_id = $1
-		LEFT JOIN messages AS m ON c.id = m.conversation_id
-		LEFT JOIN message_read AS mr ON m.id = mr.message_id AND mr.user_id = $1
 		LEFT JOIN LATERAL (
 			SELECT u.id AS user_id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted AS user_deleted
 			FROM participants AS p
@@ -115,23 +167,22 @@ func GetConversations(userId string) ([]Conversation, error) {
 		) u ON true
 		LEFT JOIN LATERAL(
 			SELECT conversation_id, text, lm.created_at,
-				CASE WHEN media_url IS NULL THEN FALSE ELSE TRUE END AS is_media,
-				CASE WHEN original_id IS NULL THEN FALSE ELSE TRUE END AS is_repost,
+				lm.has_media AS is_media,
+				CASE WHEN original_id IS NULL AND NOT original_deleted THEN FALSE ELSE TRUE END AS is_repost,
 				CASE WHEN post_id IS NULL THEN FALSE ELSE TRUE END AS is_post,
+				lm.kind, lm.event_type,
 				lu.id AS m_user_id, lu.username AS m_username, lu.name AS m_name, lu.avatar_url AS m_avatar_url,
 				lu.avatar_type AS m_avatar_type, lu.is_deleted AS m_user_deleted
 			FROM messages AS lm
 			LEFT JOIN USERS AS lu ON lm.user_id = lu.id
 			WHERE conversation_id = c.id AND (lu.id = $1 AND lm.is_deleted = 0 OR lu.id != $1 AND lm.is_deleted != 1)
+				AND (lm.expires_at IS NULL OR lm.expires_at > Now()) AND lm.scheduled_at IS NULL
 			ORDER BY lm.created_at DESC
 			LIMIT 1
 		) lm ON TRUE
-		WHERE c.is_deleted != 1
-		GROUP BY c.id, u.user_id, u.username, u.name, u.avatar_url, u.avatar_type, u.user_deleted,
-			lm.conversation_id, lm.text, lm.created_at, lm.is_media, lm.is_repost, lm.is_post,
-			lm.m_user_id, lm.m_username, lm.m_name, lm.m_avatar_url, lm.m_avatar_type, lm.m_user_deleted
-		ORDER BY c.created_at DESC, lm.created_at DESC;
-	`, userId)
+		WHERE c.is_deleted != 1 AND `+where+`
+		ORDER BY o.pinned_at IS NULL, o.pinned_at DESC, COALESCE(lm.created_at, c.created_at) DESC;
+	`, append([]any{userId}, args...)...)
 
 	result := make([]Conversation, 0)
 
@@ -147,19 +198,32 @@ func GetConversations(userId string) ([]Conversation, error) {
 		return nil, err
 	}
 
+	users := make([]*MessageUser, 0)
+	for _, c := range result {
+		if c.User != nil {
+			users = append(users, c.User)
+		}
+	}
+
+	err = attachPresence(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 
 type convSmall struct {
-	ConvType, CreatorId        string
-	CanAddUsers, HasInviteLink bool
+	ConvType, CreatorId string
+	Permissions         ConversationPermissions
 }
 
-func getConversationById(id string) (*convSmall, error) {
+func getConversationById(ctx context.Context, id string) (*convSmall, error) {
 	var c convSmall
 
-	row := db.Client.QueryRow(`
-		SELECT type, creator_id, can_add_users, has_invite_link
+	row := db.Client.QueryRowContext(ctx, `
+		SELECT type, creator_id,
+			add_users_permission, edit_info_permission, pin_messages_permission, delete_messages_permission
 		FROM conversations
 		WHERE id = $1 AND is_deleted != 1;
 	`, id)
@@ -171,8 +235,11 @@ func getConversationById(id string) (*convSmall, error) {
 	return &c, nil
 }
 
-func AddUsersToConversation(userId, convId string, users []string) error {
-	c, err := getConversationById(convId)
+func AddUsersToConversation(ctx context.Context, userId, convId string, users []string) error {
+	ctx, span := tracing.Start(ctx, "services.AddUsersToConversation")
+	defer span.End()
+
+	c, err := getConversationById(ctx, convId)
 	if err != nil {
 		return err
 	}
@@ -181,18 +248,27 @@ func AddUsersToConversation(userId, convId string, users []string) error {
 		return ErrPrivateConversation
 	}
 
-	if !c.CanAddUsers && userId != c.CreatorId {
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	if !roleAllows(role, c.Permissions.AddUsers) {
 		return ErrCannotAddUser
-	} else if c.CanAddUsers && userId != c.CreatorId && !isParticipant(convId, userId) {
-		return ErrForbidden
 	}
 
-	blocked, err := getBlockedMap(userId)
+	blocked, err := getBlockedMap(ctx, userId)
 	if err != nil {
 		return err
 	}
 
-	args := make([]any, 1, len(users)+1)
+	// users who do not accept messages from userId get the group as a request
+	requests, err := getRequestMap(ctx, userId, users)
+	if err != nil {
+		return err
+	}
+
+	args := make([]any, 1, 2*len(users)+1)
 	args[0] = convId
 	argCount := 2
 	queries := make([]string, 0, len(users))
@@ -201,43 +277,123 @@ func AddUsersToConversation(userId, convId string, users []string) error {
 		if blocked[u] {
 			continue
 		}
-		queries = append(queries, fmt.Sprintf("($1, $%d)", argCount))
-		args = append(args, u)
-		argCount++
+		queries = append(queries, fmt.Sprintf("($1, $%d, $%d)", argCount, argCount+1))
+		args = append(args, u, requests[u])
+		argCount += 2
 	}
 
-	_, err = db.Client.Exec(`
-		INSERT INTO participants (conversation_id, user_id) VALUES 
+	if len(queries) == 0 {
+		return nil
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO participants (conversation_id, user_id, is_request) VALUES 
 	`+strings.Join(queries, ", ")+`
-		ON CONFLICT ON CONSTRAINT participants_pkey DO UPDATE SET is_kicked = false;
+		ON CONFLICT ON CONSTRAINT participants_pkey
+			DO UPDATE SET is_kicked = false, has_left = false, is_request = EXCLUDED.is_request
+			WHERE participants.is_kicked OR participants.has_left
+		RETURNING user_id;
 	`, args...)
+	if err != nil {
+		return err
+	}
 
-	return err
+	added := make([]string, 0, len(queries))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		added = append(added, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+
+	_, err = createSystemMessage(ctx, tx, convId, userId, systemMessage{
+		EventType: EventUsersAdded,
+		UserIds:   added,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func KickUser(convId, userId, requestUserId string) error {
+func KickUser(ctx context.Context, convId, userId, requestUserId string) error {
+	ctx, span := tracing.Start(ctx, "services.KickUser")
+	defer span.End()
+
 	if userId == requestUserId {
 		return ErrCannotKick
 	}
 
-	c, err := getConversationById(convId)
+	c, err := getConversationById(ctx, convId)
 	if err != nil {
 		return err
 	}
 
-	if c.ConvType == "private" || c.CreatorId != requestUserId {
+	if c.ConvType == "private" {
+		return ErrCannotKick
+	}
+
+	requestRole, err := getRole(ctx, convId, requestUserId)
+	if err != nil {
+		return err
+	}
+
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	if !roleAllows(requestRole, PermissionAdmins) || roleRank(requestRole) <= roleRank(role) {
 		return ErrCannotKick
 	}
 
-	_, err = db.Client.Exec(`
-		UPDATE participants SET is_kicked = true
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE participants SET is_kicked = true, role = 'member', role_updated_at = Now()
 		WHERE conversation_id = $1 AND user_id = $2
 	`, convId, userId)
-	return err
+	if err != nil {
+		return err
+	}
+
+	_, err = createSystemMessage(ctx, tx, convId, requestUserId, systemMessage{
+		EventType: EventUserKicked,
+		UserIds:   []string{userId},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func LeaveConversation(convId, userId string) error {
-	c, err := getConversationById(convId)
+func LeaveConversation(ctx context.Context, convId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.LeaveConversation")
+	defer span.End()
+
+	c, err := getConversationById(ctx, convId)
 	if err != nil {
 		return err
 	}
@@ -246,50 +402,67 @@ func LeaveConversation(convId, userId string) error {
 		return ErrPrivateConversation
 	}
 
-	tx, err := db.Client.Begin()
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = db.Client.Exec(`
-			UPDATE participants SET has_left = true
-			WHERE conversation_id = $1 AND user_id = $2;
-		`, convId, userId)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE participants SET has_left = true, role = 'member', role_updated_at = Now()
+		WHERE conversation_id = $1 AND user_id = $2;
+	`, convId, userId)
 	if err != nil {
 		return err
 	}
 
-	if c.CreatorId != userId {
+	// channels do not announce subscribers coming and going
+	if c.ConvType != "channel" {
+		_, err = createSystemMessage(ctx, tx, convId, userId, systemMessage{EventType: EventUserLeft})
+		if err != nil {
+			return err
+		}
+	}
+
+	if role != RoleOwner {
 		return tx.Commit()
 	}
 
-	var newCreatorId string
-	err = db.Client.QueryRow(`
+	// the longest-standing admin inherits the conversation, otherwise
+	// the member who joined first
+	var newOwnerId string
+	err = tx.QueryRowContext(ctx, `
 		SELECT p.user_id
-		FROM conversations AS c
-		INNER JOIN participants AS p ON c.id = p.conversation_id
-		LEFT JOIN users AS u ON p.user_id = u.id
-		WHERE p.user_id != $1 AND p.has_left = false AND p.is_kicked = false AND u.is_deleted = false
+		FROM participants AS p
+		INNER JOIN users AS u ON p.user_id = u.id
+		WHERE p.conversation_id = $1 AND p.user_id != $2
+			AND p.has_left = false AND p.is_kicked = false AND u.is_deleted = false
+		ORDER BY p.role = 'admin' DESC,
+			CASE WHEN p.role = 'admin' THEN p.role_updated_at ELSE p.created_at END
 		LIMIT 1;
-	`).Scan(&newCreatorId)
+	`, convId, userId).Scan(&newOwnerId)
 	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
 
 	if err == sql.ErrNoRows {
-		_, err = tx.Exec(`
+		_, err = tx.ExecContext(ctx, `
 			UPDATE conversations SET is_deleted = 1
 			WHERE id = $1;
 		`, convId)
+		if err != nil {
+			return err
+		}
 
-		return err
+		return tx.Commit()
 	}
 
-	_, err = tx.Exec(`
-		UPDATE conversations SET creator_id = $1
-		WHERE id = $2;
-	`, newCreatorId, convId)
+	err = transferOwnership(ctx, tx, convId, userId, newOwnerId, RoleMember)
 	if err != nil {
 		return err
 	}
@@ -298,42 +471,80 @@ func LeaveConversation(convId, userId string) error {
 }
 
 type ConversationInfo struct {
-	Id            string        `json:"id"`
-	Name          *string       `json:"name,omitempty"`
-	CanAddUsers   bool          `json:"canAddUsers"`
-	HasInviteLink bool          `json:"hasInviteLink"`
-	Users         []MessageUser `json:"users" noscan:""`
+	Id              string                  `json:"id"`
+	ConvType        string                  `json:"type"`
+	Name            *string                 `json:"name,omitempty"`
+	Handle          *string                 `json:"handle,omitempty"`
+	Avatar          *UserAvatar             `json:"avatar,omitempty"`
+	CanAddUsers     bool                    `json:"canAddUsers"`
+	HasInviteLink   bool                    `json:"hasInviteLink"`
+	Permissions     ConversationPermissions `json:"permissions"`
+	MessageTtl      *int                    `json:"messageTtl"`
+	SubscriberCount *int                    `json:"subscriberCount,omitempty"`
+	Role            string                  `json:"role" noscan:""`
+	Users           []MessageUser           `json:"users" noscan:""`
+	Pinned          []PinnedMessage         `json:"pinned" noscan:""`
+	Typing          []string                `json:"typing" noscan:""`
 }
 
-func GetConversationInfo(convId, userId string) (*ConversationInfo, error) {
-	rows, err := db.Client.Query(`
-		SELECT c.id, c.name, c.can_add_users, c.has_invite_link,
-			u.id AS user_id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted
+func (c *ConversationInfo) SqlClean() {
+	if c.Avatar != nil && c.Avatar.Url == nil {
+		c.Avatar = nil
+	}
+}
+
+func GetConversationInfo(ctx context.Context, convId, userId string) (*ConversationInfo, error) {
+	ctx, span := tracing.Start(ctx, "services.GetConversationInfo")
+	defer span.End()
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT c.id, c.type, c.name, c.handle, c.avatar_url, c.avatar_type,
+			c.add_users_permission = 'everyone' AS can_add_users,
+			EXISTS (
+				SELECT 1 FROM conversation_invites AS i
+				WHERE i.conversation_id = c.id AND `+inviteIsActive+`
+			) AS has_invite_link,
+			c.add_users_permission, c.edit_info_permission, c.pin_messages_permission, c.delete_messages_permission,
+			c.message_ttl,
+			CASE WHEN c.type = 'channel' THEN (
+				SELECT COUNT(*)
+				FROM participants AS sp
+				WHERE sp.conversation_id = c.id AND sp.has_left = false AND sp.is_kicked = false
+			) END AS subscriber_count,
+			u.id AS user_id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted, p.role
 		FROM conversations AS c
 		LEFT JOIN participants AS p ON c.id = p.conversation_id
 		LEFT JOIN users AS u ON p.user_id = u.id
-		WHERE c.is_deleted != 1 AND c.id = $1 AND p.has_left = false AND p.is_kicked = false;
-	`, convId)
+		WHERE c.is_deleted != 1 AND c.id = $1 AND p.has_left = false AND p.is_kicked = false
+			AND (c.type != 'channel' OR p.role != 'member' OR p.user_id = $2);
+	`, convId, userId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	users := make([]MessageUser, 0)
-	c := ConversationInfo{Users: users}
+	c := ConversationInfo{Users: make([]MessageUser, 0)}
 	foundUser := false
 
 	for rows.Next() {
 		u := MessageUser{}
-		err = scanner.Scan(rows, &c, &u)
+		var role string
+		err = scanner.Scan(rows, &c, &u, &role)
 		if err != nil {
 			return nil, err
 		}
 
-		if userId == *u.Id {
+		if u.Id != nil && userId == *u.Id {
 			foundUser = true
+			c.Role = role
+		}
+
+		// channels list their owner and admins, subscribers are only counted
+		if c.ConvType == "channel" && role == RoleMember {
+			continue
 		}
 
+		u.Role = &role
 		c.Users = append(c.Users, u)
 	}
 
@@ -341,98 +552,277 @@ func GetConversationInfo(convId, userId string) (*ConversationInfo, error) {
 		return nil, ErrForbidden
 	}
 
-	return &c, nil
-}
-
-func JoinConversation(convId, userId string) error {
-	c, err := getConversationById(convId)
-	if err != nil {
-		return err
+	users := make([]*MessageUser, 0, len(c.Users))
+	for i := range c.Users {
+		users = append(users, &c.Users[i])
 	}
 
-	if !c.HasInviteLink {
-		return ErrForbidden
+	err = attachPresence(ctx, users)
+	if err != nil {
+		return nil, err
 	}
 
-	var isKicked bool
-	err = db.Client.QueryRow(`
-		INSERT INTO participants (conversation_id, user_id)
-		VALUES ($1, $2)
-		ON CONFLICT ON CONSTRAINT participants_pkey 
-			DO UPDATE SET has_left = false
-		RETURNING is_kicked;
-	`, convId, userId).Scan(&isKicked)
+	c.Pinned, err = getPinnedMessages(ctx, convId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if isKicked {
-		return ErrUserKicked
+	typing, err := presenceStore.Typing(ctx, convId)
+	if err != nil {
+		return nil, err
 	}
+	c.Typing = without(typing, userId)
 
-	return nil
+	return &c, nil
 }
 
 type EditConversationRequest struct {
-	Name          *string `json:"name"`
-	CanAddUsers   *bool   `json:"canAddUsers"`
-	HasInviteLink *bool   `json:"hasInviteLinks"`
-	CreatorId     *string `json:"creatorId"`
+	Name        *string                  `json:"name"`
+	Handle      *string                  `json:"handle"`
+	Avatar      *Avatar                  `json:"avatar"`
+	CanAddUsers *bool                    `json:"canAddUsers"`
+	Permissions *ConversationPermissions `json:"permissions"`
+	MessageTtl  *int                     `json:"messageTtl"`
+	CreatorId   *string                  `json:"creatorId"`
 }
 
-func EditConversation(changes *EditConversationRequest, convId, userId string) error {
-	c, err := getConversationById(convId)
+func EditConversation(ctx context.Context, changes *EditConversationRequest, convId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.EditConversation")
+	defer span.End()
+
+	c, err := getConversationById(ctx, convId)
 	if err != nil {
 		return err
 	}
 
-	if c.CreatorId != userId || c.ConvType != "group" {
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	editsInfo := changes.Name != nil || changes.Avatar != nil
+	editsSettings := changes.CanAddUsers != nil || changes.Permissions != nil || changes.CreatorId != nil ||
+		changes.Handle != nil
+
+	// private conversations only have the retention timer to edit, which
+	// either side may change
+	if c.ConvType == "private" && (editsInfo || editsSettings) {
+		return ErrForbidden
+	}
+
+	if changes.Handle != nil && c.ConvType != "channel" {
 		return ErrForbidden
 	}
 
+	if c.ConvType != "private" {
+		editsInfo = editsInfo || changes.MessageTtl != nil
+		if editsInfo && !roleAllows(role, c.Permissions.EditInfo) || editsSettings && role != RoleOwner {
+			return ErrForbidden
+		}
+	}
+
 	queries := make([]string, 0)
 	args := make([]any, 0)
 	argsCount := 1
 
 	if changes.Name != nil {
+		name := strings.TrimSpace(*changes.Name)
+		if len(name) == 0 {
+			return ErrEmptyString
+		}
 		queries = append(queries, fmt.Sprintf("name = $%d", argsCount))
-		args = append(args, *changes.Name)
+		args = append(args, name)
+		argsCount++
+	}
+	if changes.Avatar != nil {
+		if changes.Avatar.Url == "" {
+			changes.Avatar.Type = changes.Avatar.Url
+		}
+		queries = append(queries, fmt.Sprintf("avatar_url = $%d, avatar_type = $%d", argsCount, argsCount+1))
+		args = append(args, ToNullString(&changes.Avatar.Url), ToNullString(&changes.Avatar.Type))
+		argsCount += 2
+	}
+	if changes.Handle != nil {
+		// an empty handle makes the channel reachable by invite links only
+		if *changes.Handle != "" {
+			err = checkHandle(ctx, *changes.Handle, convId)
+			if err != nil {
+				return err
+			}
+		}
+		queries = append(queries, fmt.Sprintf("handle = $%d", argsCount))
+		args = append(args, ToNullString(changes.Handle))
 		argsCount++
 	}
 	if changes.CanAddUsers != nil {
-		queries = append(queries, fmt.Sprintf("can_add_users = $%d", argsCount))
-		args = append(args, *changes.CanAddUsers)
+		// the legacy flag maps like migration 011 did, false left adding
+		// users to the creator alone
+		level := PermissionOwner
+		if *changes.CanAddUsers {
+			level = PermissionEveryone
+		}
+		queries = append(queries, fmt.Sprintf("add_users_permission = $%d", argsCount))
+		args = append(args, level)
 		argsCount++
 	}
-	if changes.HasInviteLink != nil {
-		queries = append(queries, fmt.Sprintf("has_invite_link = $%d", argsCount))
-		args = append(args, *changes.HasInviteLink)
+	if p := changes.Permissions; p != nil {
+		levels := []struct{ column, level string }{
+			{"add_users_permission", p.AddUsers},
+			{"edit_info_permission", p.EditInfo},
+			{"pin_messages_permission", p.PinMessages},
+			{"delete_messages_permission", p.DeleteMessages},
+		}
+		for _, l := range levels {
+			if l.level == "" {
+				continue
+			}
+			if !isPermissionLevel(l.level) {
+				return ErrWrongData
+			}
+			queries = append(queries, fmt.Sprintf("%s = $%d", l.column, argsCount))
+			args = append(args, l.level)
+			argsCount++
+		}
+	}
+
+	if changes.MessageTtl != nil {
+		if !isValidMessageTtl(*changes.MessageTtl) {
+			return ErrWrongData
+		}
+		var ttl *int
+		if *changes.MessageTtl != MessageTtlOff {
+			ttl = changes.MessageTtl
+		}
+		queries = append(queries, fmt.Sprintf("message_ttl = $%d", argsCount))
+		args = append(args, ttl)
 		argsCount++
 	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	if changes.CreatorId != nil && *changes.CreatorId != userId {
-		if !isParticipant(convId, *changes.CreatorId) {
+		if !isParticipant(ctx, convId, *changes.CreatorId) {
 			return ErrForbidden
 		}
-		queries = append(queries, fmt.Sprintf("creator_id = $%d", argsCount))
-		args = append(args, *changes.CreatorId)
-		argsCount++
+		err = transferOwnership(ctx, tx, convId, userId, *changes.CreatorId, RoleAdmin)
+		if err != nil {
+			return err
+		}
 	}
 
-	args = append(args, convId)
+	if changes.Name != nil {
+		name := strings.TrimSpace(*changes.Name)
+		_, err = createSystemMessage(ctx, tx, convId, userId, systemMessage{
+			EventType: EventConversationRenamed,
+			Text:      &name,
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-	_, err = db.Client.Exec(
-		"UPDATE conversations SET\n"+strings.Join(queries, ", ")+fmt.Sprintf("\nWHERE id = $%d", argsCount),
-		args...,
-	)
+	if changes.MessageTtl != nil {
+		_, err = createSystemMessage(ctx, tx, convId, userId, systemMessage{
+			EventType: EventMessageTtlChanged,
+			Text:      messageTtlText(*changes.MessageTtl),
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-	return err
+	if len(queries) != 0 {
+		args = append(args, convId)
+
+		_, err = tx.ExecContext(ctx,
+			"UPDATE conversations SET\n"+strings.Join(queries, ", ")+fmt.Sprintf("\nWHERE id = $%d", argsCount),
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func DeleteConversation(convId, userId string) error {
-	_, err := db.Client.Exec(`
+func DeleteConversation(ctx context.Context, convId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.DeleteConversation")
+	defer span.End()
+
+	_, err := db.Client.ExecContext(ctx, `
 		UPDATE conversations SET is_deleted = 1
 		WHERE id = $1 AND creator_id = $2;
 	`, convId, userId)
 
 	return err
 }
+
+type ConversationSettingsRequest struct {
+	MutedUntil *string `json:"mutedUntil"`
+	IsArchived *bool   `json:"isArchived"`
+	IsPinned   *bool   `json:"isPinned"`
+	IsUnread   *bool   `json:"isUnread"`
+}
+
+func UpdateConversationSettings(ctx context.Context, convId, userId string, settings *ConversationSettingsRequest) error {
+	ctx, span := tracing.Start(ctx, "services.UpdateConversationSettings")
+	defer span.End()
+
+	if !isParticipant(ctx, convId, userId) {
+		return ErrForbidden
+	}
+
+	queries := make([]string, 0)
+	args := make([]any, 0)
+	argsCount := 1
+
+	if settings.MutedUntil != nil {
+		var mutedUntil *time.Time
+		if *settings.MutedUntil != "" {
+			t, err := time.Parse(time.RFC3339, *settings.MutedUntil)
+			if err != nil {
+				return ErrWrongData
+			}
+			mutedUntil = &t
+		}
+		queries = append(queries, fmt.Sprintf("muted_until = $%d", argsCount))
+		args = append(args, mutedUntil)
+		argsCount++
+	}
+	if settings.IsArchived != nil {
+		queries = append(queries, fmt.Sprintf("is_archived = $%d", argsCount))
+		args = append(args, *settings.IsArchived)
+		argsCount++
+	}
+	if settings.IsPinned != nil {
+		if *settings.IsPinned {
+			queries = append(queries, "pinned_at = COALESCE(pinned_at, Now())")
+		} else {
+			queries = append(queries, "pinned_at = NULL")
+		}
+	}
+	if settings.IsUnread != nil {
+		queries = append(queries, fmt.Sprintf("marked_unread = $%d", argsCount))
+		args = append(args, *settings.IsUnread)
+		argsCount++
+	}
+
+	if len(queries) == 0 {
+		return nil
+	}
+
+	args = append(args, convId, userId)
+
+	_, err := db.Client.ExecContext(ctx,
+		"UPDATE participants SET\n"+strings.Join(queries, ", ")+
+			fmt.Sprintf("\nWHERE conversation_id = $%d AND user_id = $%d", argsCount, argsCount+1),
+		args...,
+	)
+
+	return err
+}
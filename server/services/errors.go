@@ -15,3 +15,10 @@ var ErrForbidden = errors.New("forbidden")
 var ErrUserKicked = errors.New("user has been kicked")
 var ErrWrongData = errors.New("wrong data")
 var ErrBlocked = errors.New("you has been blocked by the user")
+var ErrInvalidEmoji = errors.New("invalid emoji")
+var ErrInvalidInvite = errors.New("invite link is invalid or expired")
+var ErrCommentsDisabled = errors.New("comments are disabled for this post")
+var ErrChannelNotFound = errors.New("channel not found")
+var ErrExportNotFound = errors.New("export not found or expired")
+var ErrExportFailed = errors.New("export could not be created")
+var ErrPollClosed = errors.New("poll is closed")
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+
+	"github.com/yura4ka/crickter/db"
+)
+
+type ReadinessInfo struct {
+	Database         bool   `json:"database"`
+	MigrationVersion *int64 `json:"migrationVersion,omitempty"`
+}
+
+func GetReadiness(ctx context.Context) (*ReadinessInfo, error) {
+	var info ReadinessInfo
+
+	if err := db.Ping(ctx); err != nil {
+		return &info, err
+	}
+	info.Database = true
+
+	var version int64
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT version_id
+		FROM goose_db_version
+		WHERE is_applied = TRUE
+		ORDER BY id DESC
+		LIMIT 1;
+	`).Scan(&version)
+	if err != nil {
+		return &info, err
+	}
+	info.MigrationVersion = &version
+
+	return &info, nil
+}
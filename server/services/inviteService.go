@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const inviteTokenBytes = 18
+
+// inviteIsActive is the SQL condition for an invite aliased as i that can
+// still be used to join.
+const inviteIsActive = `i.revoked_at IS NULL
+	AND (i.expires_at IS NULL OR i.expires_at > Now())
+	AND (i.max_uses IS NULL OR i.uses < i.max_uses)`
+
+type Invite struct {
+	Id        string       `json:"id"`
+	CreatedAt string       `json:"createdAt"`
+	Token     string       `json:"token"`
+	ExpiresAt *string      `json:"expiresAt"`
+	MaxUses   *int         `json:"maxUses"`
+	Uses      int          `json:"uses"`
+	RevokedAt *string      `json:"revokedAt"`
+	IsActive  bool         `json:"isActive"`
+	Creator   *MessageUser `json:"creator"`
+}
+
+func newInviteToken() (string, error) {
+	return newRandomToken(inviteTokenBytes)
+}
+
+// canManageInvites returns the role of userId when they are allowed to
+// add users to the group conversation.
+func canManageInvites(ctx context.Context, convId, userId string) (string, error) {
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return "", err
+	}
+
+	if c.ConvType == "private" {
+		return "", ErrPrivateConversation
+	}
+
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return "", err
+	}
+
+	if !roleAllows(role, c.Permissions.AddUsers) {
+		return "", ErrCannotAddUser
+	}
+
+	return role, nil
+}
+
+type CreateInviteRequest struct {
+	ExpiresIn *int `json:"expiresIn"`
+	MaxUses   *int `json:"maxUses"`
+}
+
+func CreateInvite(ctx context.Context, convId, userId string, params *CreateInviteRequest) (*Invite, error) {
+	ctx, span := tracing.Start(ctx, "services.CreateInvite")
+	defer span.End()
+
+	if params.ExpiresIn != nil && *params.ExpiresIn <= 0 || params.MaxUses != nil && *params.MaxUses <= 0 {
+		return nil, ErrWrongData
+	}
+
+	_, err := canManageInvites(ctx, convId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var expiresAt *time.Time
+	if params.ExpiresIn != nil {
+		t := time.Now().Add(time.Duration(*params.ExpiresIn) * time.Second)
+		expiresAt = &t
+	}
+
+	var id string
+	err = db.Client.QueryRowContext(ctx, `
+		INSERT INTO conversation_invites (token, conversation_id, creator_id, expires_at, max_uses)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;
+	`, token, convId, userId, expiresAt, params.MaxUses).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := getInvites(ctx, "i.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(invites) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &invites[0], nil
+}
+
+func getInvites(ctx context.Context, where string, args ...any) ([]Invite, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT i.id, i.created_at, i.token, i.expires_at, i.max_uses, i.uses, i.revoked_at,
+			`+inviteIsActive+` AS is_active,
+			u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted
+		FROM conversation_invites AS i
+		LEFT JOIN users AS u ON i.creator_id = u.id
+		WHERE `+where+`
+		ORDER BY i.created_at DESC;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanner.ScanRows(make([]Invite, 0), rows)
+}
+
+func GetInvites(ctx context.Context, convId, userId string) ([]Invite, error) {
+	ctx, span := tracing.Start(ctx, "services.GetInvites")
+	defer span.End()
+
+	_, err := canManageInvites(ctx, convId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return getInvites(ctx, "i.conversation_id = $1", convId)
+}
+
+func RevokeInvite(ctx context.Context, convId, inviteId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.RevokeInvite")
+	defer span.End()
+
+	role, err := canManageInvites(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	// members may only revoke their own invites
+	res, err := db.Client.ExecContext(ctx, `
+		UPDATE conversation_invites SET revoked_at = Now()
+		WHERE id = $1 AND conversation_id = $2 AND revoked_at IS NULL
+			AND (creator_id = $3 OR $4);
+	`, inviteId, convId, userId, roleAllows(role, PermissionAdmins))
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+type InvitePreview struct {
+	ConversationId string      `json:"conversationId"`
+	Name           *string     `json:"name"`
+	Avatar         *UserAvatar `json:"avatar,omitempty"`
+	MemberCount    int         `json:"memberCount"`
+	IsMember       bool        `json:"isMember"`
+}
+
+func (p *InvitePreview) SqlClean() {
+	if p.Avatar != nil && p.Avatar.Url == nil {
+		p.Avatar = nil
+	}
+}
+
+func GetInvitePreview(ctx context.Context, token, userId string) (*InvitePreview, error) {
+	ctx, span := tracing.Start(ctx, "services.GetInvitePreview")
+	defer span.End()
+
+	var p InvitePreview
+	row := db.Client.QueryRowContext(ctx, `
+		SELECT c.id, c.name, c.avatar_url, c.avatar_type,
+			(
+				SELECT COUNT(*)
+				FROM participants AS p
+				WHERE p.conversation_id = c.id AND p.has_left = false AND p.is_kicked = false
+			),
+			EXISTS (
+				SELECT 1
+				FROM participants AS p
+				WHERE p.conversation_id = c.id AND p.user_id = $2 AND p.has_left = false AND p.is_kicked = false
+			)
+		FROM conversation_invites AS i
+		INNER JOIN conversations AS c ON i.conversation_id = c.id
+		WHERE i.token = $1 AND c.is_deleted != 1 AND `+inviteIsActive+`;
+	`, token, userId)
+
+	err := scanner.Scan(row, &p)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvite
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// JoinByInvite adds userId to the conversation the invite points to and
+// returns its id. Joining a conversation the user is already in does not
+// consume a use.
+func JoinByInvite(ctx context.Context, token, userId string) (string, error) {
+	ctx, span := tracing.Start(ctx, "services.JoinByInvite")
+	defer span.End()
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var inviteId, convId, convType string
+	err = tx.QueryRowContext(ctx, `
+		SELECT i.id, i.conversation_id, c.type
+		FROM conversation_invites AS i
+		INNER JOIN conversations AS c ON i.conversation_id = c.id
+		WHERE i.token = $1 AND c.is_deleted != 1 AND `+inviteIsActive+`
+		FOR UPDATE OF i;
+	`, token).Scan(&inviteId, &convId, &convType)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidInvite
+	}
+	if err != nil {
+		return "", err
+	}
+
+	joined, err := joinConversation(ctx, tx, convId, convType, userId)
+	if err != nil {
+		return "", err
+	}
+	if !joined {
+		return convId, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversation_invites SET uses = uses + 1
+		WHERE id = $1;
+	`, inviteId)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return convId, nil
+}
+
+// joinConversation adds userId to the conversation unless they already
+// are a member and reports whether they joined.
+func joinConversation(ctx context.Context, tx *sql.Tx, convId, convType, userId string) (bool, error) {
+	var isKicked, hasLeft bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT is_kicked, has_left
+		FROM participants
+		WHERE conversation_id = $1 AND user_id = $2;
+	`, convId, userId).Scan(&isKicked, &hasLeft)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if isKicked {
+		return false, ErrUserKicked
+	}
+	if err == nil && !hasLeft {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO participants (conversation_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT ON CONSTRAINT participants_pkey
+			DO UPDATE SET has_left = false;
+	`, convId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	// channels do not announce subscribers coming and going
+	if convType != "channel" {
+		_, err = createSystemMessage(ctx, tx, convId, userId, systemMessage{EventType: EventUserJoined})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
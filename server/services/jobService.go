@@ -0,0 +1,9 @@
+package services
+
+// RegisterJobs registers the background job handlers and schedules
+// implemented by the services. It must be called before jobs.Start.
+func RegisterJobs() {
+	registerMessageExpiry()
+	registerScheduledPublishing()
+	registerConversationExports()
+}
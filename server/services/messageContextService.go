@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	MESSAGES_PER_PAGE     = 20
+	MAX_MESSAGES_PER_PAGE = 100
+)
+
+// MessagePage holds messages newest first. The cursors continue towards
+// older or newer messages and are nil once there are none left.
+type MessagePage struct {
+	Messages    []Message `json:"messages"`
+	OlderCursor *string   `json:"olderCursor"`
+	NewerCursor *string   `json:"newerCursor"`
+}
+
+type MessageContext struct {
+	MessagePage
+	TargetId string `json:"targetId"`
+}
+
+func clampPageSize(n, fallback int) int {
+	if n < 0 {
+		return fallback
+	}
+	return min(n, MAX_MESSAGES_PER_PAGE)
+}
+
+// getMessagesAround returns up to limit messages of the conversation
+// visible to userId strictly older or newer than the (createdAt, id)
+// position, closest first, and whether there are more beyond them.
+func getMessagesAround(ctx context.Context, userId, convId string, createdAt time.Time, id string, newer bool, limit int) ([]Message, bool, error) {
+	where := "m.conversation_id = $2 AND (m.created_at, m.id) < ($3, $4)"
+	if newer {
+		where = "m.conversation_id = $2 AND (m.created_at, m.id) > ($3, $4)"
+	}
+
+	messages, err := getMessages(ctx, userId, messageQuery{
+		where:     where,
+		args:      []any{convId, createdAt, id},
+		ascending: newer,
+		limit:     limit + 1,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	if messages == nil {
+		messages = make([]Message, 0)
+	}
+	return messages, false, nil
+}
+
+func pageCursor(messages []Message, hasMore bool) *string {
+	if !hasMore || len(messages) == 0 {
+		return nil
+	}
+	last := messages[len(messages)-1]
+	cursor := encodeCursor(last.CreatedAt, last.Id)
+	return &cursor
+}
+
+// GetMessagesPage continues from a cursor returned by GetMessageContext or
+// a previous page, towards newer messages when newer is set.
+func GetMessagesPage(ctx context.Context, convId, userId, cursor string, newer bool, limit int) (*MessagePage, error) {
+	ctx, span := tracing.Start(ctx, "services.GetMessagesPage")
+	defer span.End()
+
+	if !isParticipant(ctx, convId, userId) {
+		return nil, ErrForbidden
+	}
+
+	createdAt, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	limit = clampPageSize(limit, MESSAGES_PER_PAGE)
+	messages, hasMore, err := getMessagesAround(ctx, userId, convId, createdAt, id, newer, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	page := MessagePage{Messages: messages}
+	if newer {
+		page.NewerCursor = pageCursor(messages, hasMore)
+		slices.Reverse(page.Messages)
+	} else {
+		page.OlderCursor = pageCursor(messages, hasMore)
+	}
+
+	return &page, nil
+}
+
+// GetMessageContext returns the message along with up to before older and
+// after newer messages around it, so clients can jump to it in the history.
+func GetMessageContext(ctx context.Context, messageId, userId string, before, after int) (*MessageContext, error) {
+	ctx, span := tracing.Start(ctx, "services.GetMessageContext")
+	defer span.End()
+
+	var convId string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT conversation_id FROM messages WHERE id = $1;
+	`, messageId).Scan(&convId)
+	if err == sql.ErrNoRows {
+		return nil, ErrForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !isParticipant(ctx, convId, userId) {
+		return nil, ErrForbidden
+	}
+
+	// hidden messages cannot be jumped to either
+	target, err := getMessages(ctx, userId, messageQuery{
+		where: "m.id = $2",
+		args:  []any{messageId},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(target) == 0 {
+		return nil, ErrForbidden
+	}
+
+	m := target[0]
+
+	createdAt, err := time.Parse(time.RFC3339Nano, m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	before = clampPageSize(before, MESSAGES_PER_PAGE/2)
+	after = clampPageSize(after, MESSAGES_PER_PAGE/2)
+
+	older, hasOlder, err := getMessagesAround(ctx, userId, convId, createdAt, m.Id, false, before)
+	if err != nil {
+		return nil, err
+	}
+
+	newer, hasNewer, err := getMessagesAround(ctx, userId, convId, createdAt, m.Id, true, after)
+	if err != nil {
+		return nil, err
+	}
+
+	result := MessageContext{TargetId: m.Id}
+	result.OlderCursor = pageCursor(append([]Message{m}, older...), hasOlder)
+	result.NewerCursor = pageCursor(append([]Message{m}, newer...), hasNewer)
+
+	slices.Reverse(newer)
+	result.Messages = make([]Message, 0, len(newer)+1+len(older))
+	result.Messages = append(result.Messages, newer...)
+	result.Messages = append(result.Messages, m)
+	result.Messages = append(result.Messages, older...)
+
+	return &result, nil
+}
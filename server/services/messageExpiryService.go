@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/jobs"
+	"github.com/yura4ka/crickter/logging"
+)
+
+const (
+	expireMessagesKind     = "messages.expire"
+	expireMessagesInterval = 5 * time.Minute
+	expireMessagesBatch    = 1000
+)
+
+const (
+	MessageTtlOff   = 0
+	MessageTtlDay   = 24 * 60 * 60
+	MessageTtlWeek  = 7 * MessageTtlDay
+	MessageTtlMonth = 90 * MessageTtlDay
+)
+
+// messageNotExpired is the SQL condition hiding messages aliased as m
+// that have expired but were not swept yet.
+const messageNotExpired = "(m.expires_at IS NULL OR m.expires_at > Now())"
+
+func isValidMessageTtl(ttl int) bool {
+	switch ttl {
+	case MessageTtlOff, MessageTtlDay, MessageTtlWeek, MessageTtlMonth:
+		return true
+	}
+	return false
+}
+
+func messageTtlText(ttl int) *string {
+	if ttl == MessageTtlOff {
+		return nil
+	}
+	s := strconv.Itoa(ttl)
+	return &s
+}
+
+type expireMessagesPayload struct{}
+
+func registerMessageExpiry() {
+	jobs.Register(expireMessagesKind, func(ctx context.Context, _ expireMessagesPayload) error {
+		n, err := DeleteExpiredMessages(ctx)
+		if n != 0 {
+			logging.FromContext(ctx).Info("deleted expired messages", slog.Int64("count", n))
+		}
+		if err != nil {
+			return err
+		}
+
+		n, err = deleteOldTombstones(ctx)
+		if n != 0 {
+			logging.FromContext(ctx).Info("deleted message tombstones", slog.Int64("count", n))
+		}
+		return err
+	})
+	jobs.Schedule(expireMessagesKind, expireMessagesInterval, expireMessagesPayload{})
+}
+
+// DeleteExpiredMessages hard-deletes messages past their expiry in
+// batches. Their changes history, reactions and pins go with them through
+// the foreign keys, and a tombstone is left for sync.
+func DeleteExpiredMessages(ctx context.Context) (int64, error) {
+	var total int64
+
+	for {
+		res, err := db.Client.ExecContext(ctx, `
+			DELETE FROM messages
+			WHERE id IN (
+				SELECT id
+				FROM messages
+				WHERE expires_at <= Now()
+				LIMIT $1
+			);
+		`, expireMessagesBatch)
+		if err != nil {
+			return total, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+		if n < expireMessagesBatch {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const MAX_MESSAGE_MEDIA = 10
+
+// messageMediaJson aggregates the attachments aliased as mm into the
+// PostMedia JSON shape.
+const messageMediaJson = `jsonb_agg(jsonb_build_object(
+		'id', mm.id,
+		'url', mm.url,
+		'urlModifiers', mm.url_modifiers,
+		'type', mm.type,
+		'mime', mm.mime,
+		'subtype', mm.subtype,
+		'width', mm.width,
+		'height', mm.height
+	) ORDER BY mm.position)`
+
+// messageMediaQuery is a lateral subquery returning the current
+// attachments of the message aliased as m.
+const messageMediaQuery = `
+	SELECT ` + messageMediaJson + ` AS media
+	FROM message_media AS mm
+	WHERE mm.message_id = m.id AND mm.is_deleted = FALSE`
+
+type MessageMediaList []PostMedia
+
+func (l *MessageMediaList) Scan(src any) error {
+	return scanJson(src, l)
+}
+
+func validateMessageMedia(media []PostMedia) error {
+	if len(media) > MAX_MESSAGE_MEDIA {
+		return ErrWrongData
+	}
+	for _, v := range media {
+		if v.Url == "" || v.Type == "" {
+			return ErrWrongData
+		}
+	}
+	return nil
+}
+
+// setMessageMedia makes media the attachment list of the message,
+// soft-deleting the attachments that are no longer present.
+func setMessageMedia(ctx context.Context, tx *sql.Tx, messageId string, media []PostMedia) error {
+	args := []any{messageId}
+	keep := make([]string, 0, len(media))
+	for _, v := range media {
+		if v.Id == "" {
+			continue
+		}
+		args = append(args, v.Id)
+		keep = append(keep, fmt.Sprintf("$%d", len(args)))
+	}
+
+	query := `
+		UPDATE message_media SET is_deleted = TRUE
+		WHERE message_id = $1 AND is_deleted = FALSE`
+	if len(keep) != 0 {
+		query += " AND id NOT IN (" + strings.Join(keep, ", ") + ")"
+	}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if len(media) == 0 {
+		return nil
+	}
+
+	args = make([]any, 0, len(media)*10)
+	values := make([]string, 0, len(media))
+
+	for i, v := range media {
+		order := i * 10
+		values = append(values, fmt.Sprintf("(COALESCE(NULLIF($%d, '')::uuid, gen_random_uuid()), $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			order+1, order+2, order+3, order+4, order+5, order+6, order+7, order+8, order+9, order+10))
+		args = append(args, v.Id, messageId, i, v.Url, v.UrlModifiers, v.Type, v.Mime, v.Subtype, v.Height, v.Width)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_media (id, message_id, position, url, url_modifiers, type, mime, subtype, height, width) VALUES
+	`+strings.Join(values, ", ")+`
+		ON CONFLICT (id) DO UPDATE SET is_deleted = FALSE, position = EXCLUDED.position
+		WHERE message_media.message_id = EXCLUDED.message_id;
+	`, args...)
+
+	return err
+}
+
+// recordMediaChange stores the current attachments of the message in its
+// changes history. The row written by the on_message_change trigger in the
+// same transaction still holds the previous attachments and is updated,
+// otherwise a new one is added.
+func recordMediaChange(ctx context.Context, tx *sql.Tx, messageId string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE message_changes SET media = (
+			SELECT `+messageMediaJson+`
+			FROM message_media AS mm
+			WHERE mm.message_id = $1 AND mm.is_deleted = FALSE
+		)
+		WHERE message_id = $1 AND created_at = Now();
+	`, messageId)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 0 {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_changes (message_id, text, is_deleted, media)
+		SELECT m.id, m.text, m.is_deleted, (
+			SELECT `+messageMediaJson+`
+			FROM message_media AS mm
+			WHERE mm.message_id = m.id AND mm.is_deleted = FALSE
+		)
+		FROM messages AS m
+		WHERE m.id = $1;
+	`, messageId)
+
+	return err
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/realtime"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const maxEmojiLength = 32
+
+// messageReactionsQuery aggregates reactions of m.id, marking the ones
+// left by the user passed as $1.
+const messageReactionsQuery = `
+	SELECT jsonb_agg(jsonb_build_object(
+		'emoji', emoji,
+		'count', count,
+		'reacted', reacted
+	) ORDER BY first_at) AS reactions
+	FROM (
+		SELECT emoji, COUNT(*) AS count, bool_or(user_id = $1) AS reacted, MIN(created_at) AS first_at
+		FROM message_reactions
+		WHERE message_id = m.id
+		GROUP BY emoji
+	) mrc
+`
+
+type reactionEvent struct {
+	ConversationId string `json:"conversationId"`
+	MessageId      string `json:"messageId"`
+	UserId         string `json:"userId"`
+	Emoji          string `json:"emoji"`
+	Added          bool   `json:"added"`
+}
+
+func isEmoji(s string) bool {
+	if len(s) == 0 || len(s) > maxEmojiLength {
+		return false
+	}
+
+	hasSymbol := false
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsLetter(r) {
+			return false
+		}
+		if r > unicode.MaxASCII {
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
+
+func canReactToMessage(ctx context.Context, messageId, userId string) (string, error) {
+	var convId, convType string
+	var isDeleted int
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT m.conversation_id, c.type, m.is_deleted
+		FROM messages AS m
+		INNER JOIN conversations AS c ON m.conversation_id = c.id
+		WHERE m.id = $1 AND m.scheduled_at IS NULL AND c.is_deleted != 1;
+	`, messageId).Scan(&convId, &convType, &isDeleted)
+	if err != nil {
+		return "", err
+	}
+
+	if isDeleted == 1 || !isParticipant(ctx, convId, userId) {
+		return "", ErrForbidden
+	}
+
+	if convType == "private" {
+		if err := checkPrivateBlocked(ctx, convId, userId); err != nil {
+			return "", err
+		}
+	}
+
+	return convId, nil
+}
+
+func ToggleMessageReaction(ctx context.Context, messageId, userId, emoji string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.ToggleMessageReaction")
+	defer span.End()
+
+	emoji = strings.TrimSpace(emoji)
+	if !isEmoji(emoji) {
+		return false, ErrInvalidEmoji
+	}
+
+	convId, err := canReactToMessage(ctx, messageId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	// inserting first keeps concurrent toggles from failing on the key,
+	// the reaction is removed only when it was already there
+	var inserted string
+	err = db.Client.QueryRowContext(ctx, `
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING emoji;
+	`, messageId, userId, emoji).Scan(&inserted)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	added := err == nil
+	if added {
+		metrics.Reactions.WithLabelValues("message", "add").Inc()
+	} else {
+		_, err = db.Client.ExecContext(ctx, `
+			DELETE FROM message_reactions
+			WHERE message_id = $1 AND user_id = $2 AND emoji = $3;
+		`, messageId, userId, emoji)
+		if err != nil {
+			return false, err
+		}
+		metrics.Reactions.WithLabelValues("message", "remove").Inc()
+	}
+
+	publishReaction(ctx, reactionEvent{
+		ConversationId: convId, MessageId: messageId, UserId: userId, Emoji: emoji, Added: added,
+	})
+	return added, nil
+}
+
+func RemoveMessageReaction(ctx context.Context, messageId, userId, emoji string) error {
+	ctx, span := tracing.Start(ctx, "services.RemoveMessageReaction")
+	defer span.End()
+
+	convId, err := canReactToMessage(ctx, messageId, userId)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Client.ExecContext(ctx, `
+		DELETE FROM message_reactions
+		WHERE message_id = $1 AND user_id = $2 AND emoji = $3;
+	`, messageId, userId, strings.TrimSpace(emoji))
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n != 0 {
+		metrics.Reactions.WithLabelValues("message", "remove").Inc()
+		publishReaction(ctx, reactionEvent{
+			ConversationId: convId, MessageId: messageId, UserId: userId, Emoji: emoji, Added: false,
+		})
+	}
+	return nil
+}
+
+func publishReaction(ctx context.Context, e reactionEvent) {
+	participants, err := getParticipantIds(ctx, e.ConversationId)
+	if err != nil {
+		return
+	}
+	realtime.Publish(participants, realtime.Event{Type: "reaction", Data: e})
+}
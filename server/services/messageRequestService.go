@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	MessagePrivacyEveryone  = "everyone"
+	MessagePrivacyFollowing = "following"
+	MessagePrivacyNobody    = "nobody"
+)
+
+func isValidMessagePrivacy(s string) bool {
+	return s == MessagePrivacyEveryone || s == MessagePrivacyFollowing || s == MessagePrivacyNobody
+}
+
+// getRequestMap returns the users whose message privacy does not let
+// senderId reach them directly, so conversations started by senderId land
+// in their message requests instead.
+func getRequestMap(ctx context.Context, senderId string, userIds []string) (map[string]bool, error) {
+	requests := make(map[string]bool)
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT u.id
+		FROM users AS u
+		WHERE u.id = ANY($2) AND NOT (
+			u.message_privacy = 'everyone'
+			OR u.message_privacy = 'following' AND EXISTS (
+				SELECT 1
+				FROM users_followers AS f
+				WHERE f.user_id = $1 AND f.follower_id = u.id
+			)
+		);
+	`, senderId, pq.Array(userIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		requests[id] = true
+	}
+
+	return requests, rows.Err()
+}
+
+// GetMessageRequests lists the pending conversations that were started
+// with or added userId without their message privacy allowing it.
+func GetMessageRequests(ctx context.Context, userId string) ([]Conversation, error) {
+	ctx, span := tracing.Start(ctx, "services.GetMessageRequests")
+	defer span.End()
+
+	return getConversations(ctx, userId, "o.is_request = true AND o.has_left = false AND o.is_kicked = false")
+}
+
+// AcceptMessageRequest moves the conversation to the main list of userId.
+// Replying to a request accepts it as well.
+func AcceptMessageRequest(ctx context.Context, convId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.AcceptMessageRequest")
+	defer span.End()
+
+	res, err := db.Client.ExecContext(ctx, `
+		UPDATE participants SET is_request = false
+		WHERE conversation_id = $1 AND user_id = $2 AND is_request = true
+			AND has_left = false AND is_kicked = false;
+	`, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// DeclineMessageRequest deletes a requested private conversation and
+// quietly leaves a group. The sender may still start a new conversation,
+// which lands in the requests again; blocking them is what stops that.
+func DeclineMessageRequest(ctx context.Context, convId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.DeclineMessageRequest")
+	defer span.End()
+
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE participants SET is_request = false, has_left = $1
+		WHERE conversation_id = $2 AND user_id = $3 AND is_request = true
+			AND has_left = false AND is_kicked = false;
+	`, c.ConvType != "private", convId, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrForbidden
+	}
+
+	if c.ConvType == "private" {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE conversations SET is_deleted = 1
+			WHERE id = $1;
+		`, convId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
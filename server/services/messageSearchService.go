@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const MESSAGES_PER_SEARCH = 20
+
+// ts_headline marks matches with these control characters, which are
+// stripped from the text beforehand, so that the snippet can be escaped
+// before the matches are wrapped in <mark>.
+const (
+	snippetStart    = "\x02"
+	snippetStop     = "\x03"
+	headlineOptions = "StartSel=" + snippetStart + ", StopSel=" + snippetStop + ", MaxFragments=2"
+)
+
+var snippetReplacer = strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>")
+
+type MessageSearchResult struct {
+	Id               string       `json:"id"`
+	CreatedAt        string       `json:"createdAt"`
+	ConversationId   string       `json:"conversationId"`
+	ConversationType string       `json:"conversationType"`
+	ConversationName *string      `json:"conversationName,omitempty"`
+	Snippet          string       `json:"snippet"`
+	User             *MessageUser `json:"user,omitempty"`
+}
+
+// SqlClean turns the snippet into HTML that is safe to render: the
+// message text is escaped and only the <mark> tags are markup.
+func (r *MessageSearchResult) SqlClean() {
+	r.Snippet = snippetReplacer.Replace(html.EscapeString(r.Snippet))
+}
+
+type MessageSearchPage struct {
+	Messages   []MessageSearchResult `json:"messages"`
+	NextCursor *string               `json:"nextCursor"`
+}
+
+func encodeCursor(createdAt, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt + "," + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrWrongData
+	}
+
+	createdAt, id, ok := strings.Cut(string(b), ",")
+	if !ok {
+		return time.Time{}, "", ErrWrongData
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, "", ErrWrongData
+	}
+
+	return t, id, nil
+}
+
+// searchMessages matches q against messages visible to userId in the
+// conversations they currently take part in, newest first. An empty
+// convId searches across all of them.
+func searchMessages(ctx context.Context, userId, convId, q, cursor string) (*MessageSearchPage, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, ErrEmptyString
+	}
+
+	args := []any{userId, q, headlineOptions}
+	filters := make([]string, 0)
+
+	if convId != "" {
+		args = append(args, convId)
+		filters = append(filters, fmt.Sprintf("AND m.conversation_id = $%d", len(args)))
+	}
+
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, id)
+		filters = append(filters, fmt.Sprintf("AND (m.created_at, m.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	args = append(args, MESSAGES_PER_SEARCH+1)
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT m.id, m.created_at, m.conversation_id, c.type, c.name,
+			ts_headline('english', translate(m.text, chr(2) || chr(3), ''), query, $3),
+			u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted
+		FROM messages AS m
+		CROSS JOIN plainto_tsquery('english', $2) AS query
+		INNER JOIN participants AS p ON m.conversation_id = p.conversation_id AND p.user_id = $1
+			AND p.has_left = false AND p.is_kicked = false
+		INNER JOIN conversations AS c ON m.conversation_id = c.id
+		INNER JOIN users AS u ON m.user_id = u.id
+		WHERE query @@ m.message_tsv AND m.kind = 'user' AND c.is_deleted != 1 AND `+messageNotExpired+`
+			AND m.scheduled_at IS NULL AND (m.is_deleted = 0 OR m.is_deleted = 2 AND m.user_id != $1)
+			`+strings.Join(filters, "\n\t\t\t")+`
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $`+fmt.Sprint(len(args))+`;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := scanner.ScanRows(make([]MessageSearchResult, 0), rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := MessageSearchPage{Messages: result}
+	if len(result) > MESSAGES_PER_SEARCH {
+		page.Messages = result[:MESSAGES_PER_SEARCH]
+		last := page.Messages[MESSAGES_PER_SEARCH-1]
+		next := encodeCursor(last.CreatedAt, last.Id)
+		page.NextCursor = &next
+	}
+
+	return &page, nil
+}
+
+func SearchConversation(ctx context.Context, convId, userId, q, cursor string) (*MessageSearchPage, error) {
+	ctx, span := tracing.Start(ctx, "services.SearchConversation")
+	defer span.End()
+
+	if !isParticipant(ctx, convId, userId) {
+		return nil, ErrForbidden
+	}
+
+	return searchMessages(ctx, userId, convId, q, cursor)
+}
+
+func SearchMessages(ctx context.Context, userId, q, cursor string) (*MessageSearchPage, error) {
+	ctx, span := tracing.Start(ctx, "services.SearchMessages")
+	defer span.End()
+
+	return searchMessages(ctx, userId, "", q, cursor)
+}
@@ -1,31 +1,33 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/realtime"
 	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
 )
 
-type MessageMedia struct {
-	Type *string `json:"type"`
-	Url  *string `json:"url"`
-}
-
 type CreateMessageRequest struct {
-	ConversationId string        `json:"conversationId"`
-	Text           *string       `json:"text"`
-	OriginalId     *string       `json:"originalId"`
-	ResponseToId   *string       `json:"responseToId"`
-	PostId         *string       `json:"postId"`
-	Media          *MessageMedia `json:"media"`
+	ConversationId string       `json:"conversationId"`
+	Text           *string      `json:"text"`
+	OriginalId     *string      `json:"originalId"`
+	ResponseToId   *string      `json:"responseToId"`
+	PostId         *string      `json:"postId"`
+	Media          []PostMedia  `json:"media"`
+	ScheduledAt    *string      `json:"scheduledAt"`
+	Poll           *PollRequest `json:"poll"`
 }
 
-func isParticipant(convId, userId string) bool {
+func isParticipant(ctx context.Context, convId, userId string) bool {
 	result := false
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT true
 		FROM participants
 		WHERE conversation_id = $1 AND user_id = $2 AND has_left = false AND is_kicked = false;
@@ -36,88 +38,256 @@ func isParticipant(convId, userId string) bool {
 	return result
 }
 
-func CreateMessage(message *CreateMessageRequest, userId string) (string, error) {
-	if !isParticipant(message.ConversationId, userId) {
-		return "", ErrForbidden
+// checkPrivateBlocked returns ErrBlocked when the other participant of the
+// private conversation has blocked userId.
+func checkPrivateBlocked(ctx context.Context, convId, userId string) error {
+	var receiver string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT user_id
+		FROM participants
+		WHERE conversation_id = $1 AND user_id != $2
+		LIMIT 1;
+	`, convId, userId).Scan(&receiver)
+	if err != nil {
+		return err
 	}
 
-	c, err := getConversationById(message.ConversationId)
+	isBlocked, err := IsUserBlocked(ctx, receiver, userId)
+	if err != nil {
+		return err
+	}
+	if isBlocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// checkCanSendContent makes sure userId may write to the conversation and
+// share postId there when it is set. Scheduled messages run it again when
+// they are published.
+func checkCanSendContent(ctx context.Context, convId, userId string, postId *string) error {
+	err := checkCanSendMessage(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	if postId != nil {
+		authorId, err := checkPostAvailable(ctx, *postId, userId)
+		if err != nil {
+			return err
+		}
+
+		return checkPostRecipient(ctx, authorId, convId, userId)
+	}
+
+	return nil
+}
+
+func CreateMessage(ctx context.Context, message *CreateMessageRequest, userId string) (string, error) {
+	ctx, span := tracing.Start(ctx, "services.CreateMessage")
+	defer span.End()
+
+	err := checkCanSendContent(ctx, message.ConversationId, userId, message.PostId)
 	if err != nil {
 		return "", err
 	}
 
-	if c.ConvType == "private" {
-		var receiver string
-		err := db.Client.QueryRow(`
-			SELECT user_id
-			FROM participants
-			WHERE user_id != $1
-			LIMIT 1;
-		`, userId).Scan(&receiver)
+	err = validateMessageMedia(message.Media)
+	if err != nil {
+		return "", err
+	}
+
+	scheduledAt, err := parseScheduledAt(message.ScheduledAt)
+	if err != nil {
+		return "", err
+	}
+
+	var pollClosesAt *time.Time
+	if message.Poll != nil {
+		pollClosesAt, err = checkMessagePoll(ctx, message.ConversationId, message.Poll, scheduledAt)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var id string
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO messages (user_id, conversation_id, text, original_id, response_to_id, post_id, has_media, scheduled_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at;
+	`, &userId, &message.ConversationId, &message.Text, &message.OriginalId, &message.ResponseToId, &message.PostId,
+		len(message.Media) != 0, scheduledAt,
+	).Scan(&id, &createdAt)
+	if err != nil {
+		return "", err
+	}
+
+	if len(message.Media) != 0 {
+		err = setMessageMedia(ctx, tx, id, message.Media)
 		if err != nil {
 			return "", err
 		}
-		isBlocked, err := IsUserBlocked(receiver, userId)
+
+		err = recordMediaChange(ctx, tx, id)
 		if err != nil {
 			return "", err
 		}
-		if isBlocked {
-			return "", ErrBlocked
+	}
+
+	if message.Poll != nil {
+		err = createPoll(ctx, tx, pollOwnerMessage, id, message.Poll, pollClosesAt)
+		if err != nil {
+			return "", err
 		}
 	}
 
-	var mUrl, mType *string
-	if message.Media != nil {
-		mUrl = message.Media.Url
-		mType = message.Media.Type
+	if scheduledAt != nil {
+		err = enqueuePublish(ctx, tx, publishMessageKind, id, *scheduledAt)
+		if err != nil {
+			return "", err
+		}
+
+		return id, tx.Commit()
 	}
 
-	tx, err := db.Client.Begin()
+	err = markSentMessageRead(ctx, tx, message.ConversationId, userId, id, createdAt)
 	if err != nil {
 		return "", err
 	}
-	defer tx.Rollback()
 
-	var id string
-	err = tx.QueryRow(`
-		INSERT INTO messages (user_id, conversation_id, text, original_id, response_to_id, post_id, media_type, media_url)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id;
-	`, &userId, &message.ConversationId, &message.Text, &message.OriginalId, &message.ResponseToId, &message.PostId, &mUrl, &mType).
-		Scan(&id)
+	err = tx.Commit()
 	if err != nil {
 		return "", err
 	}
 
-	_, err = tx.Exec(`
-		INSERT INTO message_read (message_id, user_id)
-		VALUES ($1, $2)
-	`, id, userId)
+	metrics.MessagesSent.Inc()
+	_ = presenceStore.ClearTyping(ctx, message.ConversationId, userId)
+	publishNotification(ctx, messageEvent{
+		ConversationId: message.ConversationId,
+		MessageId:      id,
+		UserId:         userId,
+		Text:           message.Text,
+		CreatedAt:      createdAt,
+	})
+	return id, nil
+}
+
+// markSentMessageRead moves the read watermark of the sender to the
+// message they have just sent. Replying also accepts a message request.
+func markSentMessageRead(ctx context.Context, tx *sql.Tx, convId, userId, messageId string, createdAt time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE participants SET last_read_message_id = $1, last_read_at = $2, is_request = false
+		WHERE conversation_id = $3 AND user_id = $4;
+	`, messageId, createdAt, convId, userId)
+	return err
+}
+
+type messageEvent struct {
+	ConversationId string    `json:"conversationId"`
+	MessageId      string    `json:"messageId"`
+	UserId         string    `json:"userId"`
+	Text           *string   `json:"text,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// getNotificationRecipients returns the participants that should be
+// notified about a new message: everyone except the sender, those who
+// muted the conversation and those who have not accepted it as a request.
+func getNotificationRecipients(ctx context.Context, convId, senderId string) ([]string, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT user_id
+		FROM participants
+		WHERE conversation_id = $1 AND user_id != $2 AND has_left = false AND is_kicked = false
+			AND is_request = false
+			AND (muted_until IS NULL OR muted_until <= Now());
+	`, convId, senderId)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
 	}
 
-	return id, tx.Commit()
+	return result, rows.Err()
 }
 
-func GetMessages(convId, userId string) ([]Message, error) {
-	if !isParticipant(convId, userId) {
+func publishNotification(ctx context.Context, e messageEvent) {
+	recipients, err := getNotificationRecipients(ctx, e.ConversationId, e.UserId)
+	if err != nil {
+		return
+	}
+	realtime.Publish(recipients, realtime.Event{Type: "notification", Data: e})
+}
+
+func GetMessages(ctx context.Context, convId, userId string) ([]Message, error) {
+	ctx, span := tracing.Start(ctx, "services.GetMessages")
+	defer span.End()
+	defer metrics.TimeQuery("get_messages")()
+
+	if !isParticipant(ctx, convId, userId) {
 		return nil, ErrForbidden
 	}
 
+	return getMessages(ctx, userId, messageQuery{
+		where: "m.conversation_id = $2",
+		args:  []any{convId},
+	})
+}
+
+// messageQuery selects messages for getMessages. The where condition can
+// refer to the message m and the participant row rp of the user, its args
+// are numbered from $2.
+type messageQuery struct {
+	where string
+	args  []any
+	// ascending returns the oldest messages first
+	ascending bool
+	limit     int
+}
+
+// getMessages returns the messages matching q that userId can see.
+func getMessages(ctx context.Context, userId string, q messageQuery) ([]Message, error) {
 	var result []Message
 
-	rows, err := db.Client.Query(`
-		SELECT m.*,
-			CASE WHEN mr.message_id IS NOT NULL THEN TRUE ELSE FALSE END AS is_read,
+	order := "ORDER BY m.created_at DESC, m.id DESC"
+	if q.ascending {
+		order = "ORDER BY m.created_at ASC, m.id ASC"
+	}
+	if q.limit > 0 {
+		order += fmt.Sprintf("\nLIMIT %d", q.limit)
+	}
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT m.id, m.created_at, m.updated_at, m.expires_at, m.scheduled_at, m.text, mm.media, m.is_deleted,
+			m.user_id, m.conversation_id, m.original_id, m.original_deleted, m.response_to_id, m.post_id,
+			m.kind, m.event_type, m.event_user_ids, m.event_message_id,
+			CASE WHEN m.user_id = $1 OR m.created_at <= rp.last_read_at THEN TRUE ELSE FALSE END AS is_read,
+			r.reactions,
 			u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted
 		FROM messages AS m
-		LEFT JOIN message_read AS mr ON m.id = mr.message_id AND mr.user_id = $1
+		LEFT JOIN participants AS rp ON m.conversation_id = rp.conversation_id AND rp.user_id = $1
 		INNER JOIN USERS AS u ON m.user_id = u.id
-		WHERE m.conversation_id = $2
+		LEFT JOIN LATERAL (`+messageMediaQuery+`) mm ON TRUE
+		LEFT JOIN LATERAL (`+messageReactionsQuery+`) r ON TRUE
+		WHERE `+q.where+` AND `+messageNotExpired+`
+			AND (m.scheduled_at IS NULL OR m.user_id = $1)
 			AND (m.is_deleted = 0 OR m.is_deleted = 2 AND m.user_id != $1)
-		ORDER BY m.created_at DESC;
-	`, userId, convId)
+		`+order+`;
+	`, append([]any{userId}, q.args...)...)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -127,15 +297,28 @@ func GetMessages(convId, userId string) ([]Message, error) {
 	}
 
 	result, err = scanner.ScanRows(result, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = attachEventUsers(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	err = attachMessagePolls(ctx, userId, result)
 	return result, err
 }
 
 type EditMessageRequest struct {
-	Text  *string       `json:"text,omitempty"`
-	Media *MessageMedia `json:"media,omitempty"`
+	Text  *string     `json:"text,omitempty"`
+	Media []PostMedia `json:"media,omitempty"`
 }
 
-func EditMessage(m *EditMessageRequest, userId, messageId string) error {
+func EditMessage(ctx context.Context, m *EditMessageRequest, userId, messageId string) error {
+	ctx, span := tracing.Start(ctx, "services.EditMessage")
+	defer span.End()
+
 	queries := make([]string, 0)
 	args := make([]any, 0)
 	argsCount := 1
@@ -147,53 +330,199 @@ func EditMessage(m *EditMessageRequest, userId, messageId string) error {
 	}
 
 	if m.Media != nil {
-		if m.Media.Url == nil || m.Media.Type == nil {
-			return ErrWrongData
-		}
-		if *m.Media.Url == "" {
-			m.Media.Type = m.Media.Url
+		if err := validateMessageMedia(m.Media); err != nil {
+			return err
 		}
-		queries = append(queries, fmt.Sprintf("media_url = $%d, media_type = $%d", argsCount, argsCount+1))
-		args = append(args, ToNullString(m.Media.Url), ToNullString(m.Media.Type))
-		argsCount += 2
+		queries = append(queries, fmt.Sprintf("has_media = $%d", argsCount))
+		args = append(args, len(m.Media) != 0)
+		argsCount++
+	}
+
+	if len(queries) == 0 {
+		return nil
 	}
 
 	args = append(args, messageId, userId)
 
-	_, err := db.Client.Exec(
-		"UPDATE messages SET\n"+strings.Join(queries, ", ")+fmt.Sprintf("\nWHERE id = $%d AND user_id = $%d", argsCount, argsCount+1),
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE messages SET\n"+strings.Join(queries, ", ")+fmt.Sprintf("\nWHERE id = $%d AND user_id = $%d AND kind = 'user'", argsCount, argsCount+1),
 		args...,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrForbidden
+		}
+		return err
+	}
+
+	if m.Media != nil {
+		err = setMessageMedia(ctx, tx, messageId, m.Media)
+		if err != nil {
+			return err
+		}
+
+		err = recordMediaChange(ctx, tx, messageId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func ReadMessage(messageId, userId string) error {
-	var convId string
+// readEvent carries the new watermark: ReadUpTo is the creation time of
+// the last read message, not the time it was read.
+type readEvent struct {
+	ConversationId string    `json:"conversationId"`
+	UserId         string    `json:"userId"`
+	MessageId      string    `json:"messageId"`
+	ReadUpTo       time.Time `json:"readUpTo"`
+}
 
-	err := db.Client.QueryRow(`
-		SELECT conversation_id
-		FROM messages 
-		WHERE id = $1;
-	`, messageId).Scan(convId)
+// ReadConversation moves the read watermark of the user up to messageId.
+// An empty messageId marks the whole conversation as read. The watermark
+// never moves backwards.
+func ReadConversation(ctx context.Context, convId, userId, messageId string) error {
+	ctx, span := tracing.Start(ctx, "services.ReadConversation")
+	defer span.End()
 
+	role, err := getRole(ctx, convId, userId)
 	if err != nil {
 		return err
 	}
 
-	if !isParticipant(convId, userId) {
-		return ErrForbidden
+	var query string
+	args := []any{convId}
+	if messageId == "" {
+		query = `
+			SELECT id, created_at
+			FROM messages
+			WHERE conversation_id = $1 AND scheduled_at IS NULL
+			ORDER BY created_at DESC
+			LIMIT 1;
+		`
+	} else {
+		query = `
+			SELECT id, created_at
+			FROM messages
+			WHERE conversation_id = $1 AND id = $2 AND scheduled_at IS NULL;
+		`
+		args = append(args, messageId)
+	}
+
+	e := readEvent{ConversationId: convId, UserId: userId}
+	err = db.Client.QueryRowContext(ctx, query, args...).Scan(&e.MessageId, &e.ReadUpTo)
+	if err == sql.ErrNoRows && messageId == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Client.ExecContext(ctx, `
+		UPDATE participants SET marked_unread = false
+		WHERE conversation_id = $1 AND user_id = $2 AND marked_unread = true;
+	`, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Client.ExecContext(ctx, `
+		UPDATE participants SET last_read_message_id = $1, last_read_at = $2
+		WHERE conversation_id = $3 AND user_id = $4
+			AND (last_read_at IS NULL OR last_read_at < $2);
+	`, e.MessageId, e.ReadUpTo, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n != 0 {
+		participants, err := readEventRecipients(ctx, convId, userId, role)
+		if err == nil {
+			realtime.Publish(participants, realtime.Event{Type: "read", Data: e})
+		}
+	}
+
+	return nil
+}
+
+// readEventRecipients returns who is told that userId read the
+// conversation. Channel subscribers are not shown to anyone else, so their
+// reads only go to their own devices.
+func readEventRecipients(ctx context.Context, convId, userId, role string) ([]string, error) {
+	if role == RoleMember {
+		c, err := getConversationById(ctx, convId)
+		if err != nil {
+			return nil, err
+		}
+		if c.ConvType == "channel" {
+			return []string{userId}, nil
+		}
 	}
+	return getParticipantIds(ctx, convId)
+}
 
-	_, err = db.Client.Exec(`
-		INSERT INTO message_read (message_id, user_id)
-		VALUES ($1, $2)
+// MessageSeen lists a participant whose watermark is at or past the
+// message. Only the watermark is stored, so ReadUpTo is the creation time
+// of the last message they read rather than when they read this one.
+type MessageSeen struct {
+	User     *MessageUser `json:"user"`
+	ReadUpTo string       `json:"readUpTo"`
+}
+
+// GetMessageSeen lists who read the message. In channels subscribers only
+// see themselves, like in the conversation info.
+func GetMessageSeen(ctx context.Context, messageId, userId string) ([]MessageSeen, error) {
+	ctx, span := tracing.Start(ctx, "services.GetMessageSeen")
+	defer span.End()
+
+	var convId string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT conversation_id
+		FROM messages
+		WHERE id = $1 AND (is_deleted = 0 OR is_deleted = 2 AND user_id != $2);
+	`, messageId, userId).Scan(&convId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isParticipant(ctx, convId, userId) {
+		return nil, ErrForbidden
+	}
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted, p.last_read_at
+		FROM messages AS m
+		INNER JOIN conversations AS c ON m.conversation_id = c.id
+		INNER JOIN participants AS p ON m.conversation_id = p.conversation_id
+		INNER JOIN users AS u ON p.user_id = u.id
+		WHERE m.id = $1 AND p.user_id != m.user_id AND p.last_read_at >= m.created_at
+			AND p.has_left = false AND p.is_kicked = false
+			AND (c.type != 'channel' OR p.role != 'member' OR p.user_id = $2)
+		ORDER BY p.last_read_at;
 	`, messageId, userId)
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	result := make([]MessageSeen, 0)
+	return scanner.ScanRows(result, rows)
 }
 
-func DeleteMessage(messageId, userId string, onlyCreator bool) error {
+func DeleteMessage(ctx context.Context, messageId, userId string, onlyCreator bool) error {
+	ctx, span := tracing.Start(ctx, "services.DeleteMessage")
+	defer span.End()
+
 	var deleteType int
 	if onlyCreator {
 		deleteType = 2
@@ -201,25 +530,69 @@ func DeleteMessage(messageId, userId string, onlyCreator bool) error {
 		deleteType = 1
 	}
 
-	_, err := db.Client.Exec(`
+	res, err := db.Client.ExecContext(ctx, `
 		UPDATE messages SET is_deleted = $1
-		WHERE id = $2 AND user_id = $3;
+		WHERE id = $2 AND user_id = $3 AND kind = 'user';
 	`, deleteType, messageId, userId)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 0 {
+		return err
+	}
+
+	// someone else's message, allowed for group roles with the permission
+	var convId string
+	err = db.Client.QueryRowContext(ctx, `
+		SELECT conversation_id FROM messages WHERE id = $1 AND kind = 'user';
+	`, messageId).Scan(&convId)
+	if err != nil {
+		return err
+	}
+
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return err
+	}
+
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	if c.ConvType == "private" || !roleAllows(role, c.Permissions.DeleteMessages) {
+		return ErrForbidden
+	}
+
+	_, err = db.Client.ExecContext(ctx, `
+		UPDATE messages SET is_deleted = 1
+		WHERE id = $1;
+	`, messageId)
 
 	return err
 }
 
 type MessageChange struct {
-	Id        string        `json:"id"`
-	CreatedAt string        `json:"createdAt"`
-	Text      *string       `json:"text"`
-	IsDeleted int           `json:"isDeleted"`
-	Media     *MessageMedia `json:"media"`
+	Id        string           `json:"id"`
+	CreatedAt string           `json:"createdAt"`
+	Text      *string          `json:"text"`
+	IsDeleted int              `json:"isDeleted"`
+	Media     MessageMediaList `json:"media"`
+}
+
+func (c *MessageChange) SqlClean() {
+	if c.Media == nil {
+		c.Media = MessageMediaList{}
+	}
 }
 
-func GetMessageChanges(messageId, userId string) ([]MessageChange, error) {
-	rows, err := db.Client.Query(`
-		SELECT c.id, c.created_at, c.text, c.is_deleted, c.media_type, c.media_url
+func GetMessageChanges(ctx context.Context, messageId, userId string) ([]MessageChange, error) {
+	ctx, span := tracing.Start(ctx, "services.GetMessageChanges")
+	defer span.End()
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT c.id, c.created_at, c.text, c.is_deleted, c.media
 		FROM messages AS m
 		LEFT JOIN message_changes AS c ON m.id = c.message_id
 		WHERE m.id = $1 AND m.user_id = $2
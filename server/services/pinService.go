@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+type PinnedMessage struct {
+	MessageId string           `json:"messageId"`
+	PinnedAt  string           `json:"pinnedAt"`
+	PinnedBy  *string          `json:"pinnedBy"`
+	Text      *string          `json:"text,omitempty"`
+	Media     MessageMediaList `json:"media"`
+	User      *MessageUser     `json:"user,omitempty"`
+}
+
+func (p *PinnedMessage) SqlClean() {
+	if p.Media == nil {
+		p.Media = MessageMediaList{}
+	}
+}
+
+// canPinMessages checks that userId may change pins of the conversation:
+// any participant of a private conversation or a group role allowed by
+// the pin permission.
+func canPinMessages(ctx context.Context, convId, userId string) error {
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return err
+	}
+
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	if c.ConvType != "private" && !roleAllows(role, c.Permissions.PinMessages) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func PinMessage(ctx context.Context, convId, messageId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.PinMessage")
+	defer span.End()
+
+	err := canPinMessages(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO pinned_messages (message_id, conversation_id, pinned_by)
+		SELECT id, conversation_id, $3
+		FROM messages
+		WHERE id = $1 AND conversation_id = $2 AND kind = 'user' AND is_deleted != 1
+			AND scheduled_at IS NULL
+		ON CONFLICT DO NOTHING;
+	`, messageId, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+
+	_, err = createSystemMessage(ctx, tx, convId, userId, systemMessage{
+		EventType: EventMessagePinned,
+		MessageId: &messageId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func UnpinMessage(ctx context.Context, convId, messageId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.UnpinMessage")
+	defer span.End()
+
+	err := canPinMessages(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM pinned_messages
+		WHERE message_id = $1 AND conversation_id = $2;
+	`, messageId, convId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+
+	_, err = createSystemMessage(ctx, tx, convId, userId, systemMessage{
+		EventType: EventMessageUnpinned,
+		MessageId: &messageId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getPinnedMessages(ctx context.Context, convId string) ([]PinnedMessage, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT pm.message_id, pm.created_at, pm.pinned_by,
+			m.text, mm.media,
+			u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted
+		FROM pinned_messages AS pm
+		INNER JOIN messages AS m ON pm.message_id = m.id
+		INNER JOIN users AS u ON m.user_id = u.id
+		LEFT JOIN LATERAL (`+messageMediaQuery+`) mm ON TRUE
+		WHERE pm.conversation_id = $1
+		ORDER BY pm.created_at DESC;
+	`, convId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return make([]PinnedMessage, 0), nil
+		}
+		return nil, err
+	}
+
+	return scanner.ScanRows(make([]PinnedMessage, 0), rows)
+}
@@ -0,0 +1,511 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/realtime"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	MIN_POLL_OPTIONS      = 2
+	MAX_POLL_OPTIONS      = 10
+	maxPollQuestionLength = 256
+	maxPollOptionLength   = 100
+	pollOwnerPost         = "post_id"
+	pollOwnerMessage      = "message_id"
+)
+
+type PollRequest struct {
+	Question  string   `json:"question"`
+	Options   []string `json:"options"`
+	Multiple  bool     `json:"multiple"`
+	Anonymous bool     `json:"anonymous"`
+	ClosesAt  *string  `json:"closesAt"`
+}
+
+type Poll struct {
+	Id        string      `json:"id"`
+	Question  string      `json:"question"`
+	Multiple  bool        `json:"multiple"`
+	Anonymous bool        `json:"anonymous"`
+	ClosesAt  *string     `json:"closesAt"`
+	IsClosed  bool        `json:"isClosed"`
+	Voters    int         `json:"voters"`
+	Options   PollOptions `json:"options"`
+}
+
+type PollOption struct {
+	Id    string `json:"id"`
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+	Voted bool   `json:"voted"`
+}
+
+type PollOptions []PollOption
+
+func (o *PollOptions) Scan(src any) error {
+	return scanJson(src, o)
+}
+
+func (p *Poll) SqlClean() {
+	if p.Options == nil {
+		p.Options = PollOptions{}
+	}
+}
+
+// validatePoll trims the poll in place. A poll of scheduled content must
+// stay open for a while after it is published.
+func validatePoll(poll *PollRequest, publishAt *time.Time) (*time.Time, error) {
+	poll.Question = strings.TrimSpace(poll.Question)
+	if len(poll.Question) == 0 || utf8.RuneCountInString(poll.Question) > maxPollQuestionLength {
+		return nil, ErrWrongData
+	}
+
+	if len(poll.Options) < MIN_POLL_OPTIONS || len(poll.Options) > MAX_POLL_OPTIONS {
+		return nil, ErrWrongData
+	}
+
+	for i, o := range poll.Options {
+		o = strings.TrimSpace(o)
+		if len(o) == 0 || utf8.RuneCountInString(o) > maxPollOptionLength || slices.Contains(poll.Options[:i], o) {
+			return nil, ErrWrongData
+		}
+		poll.Options[i] = o
+	}
+
+	closesAt, err := parseScheduledAt(poll.ClosesAt)
+	if err != nil {
+		return nil, err
+	}
+	if closesAt != nil && publishAt != nil && !closesAt.After(*publishAt) {
+		return nil, ErrWrongData
+	}
+
+	return closesAt, nil
+}
+
+// checkMessagePoll validates a poll sent to a conversation. Polls are
+// meant for groups and channels, not private conversations.
+func checkMessagePoll(ctx context.Context, convId string, poll *PollRequest, publishAt *time.Time) (*time.Time, error) {
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return nil, err
+	}
+	if c.ConvType == "private" {
+		return nil, ErrWrongData
+	}
+
+	return validatePoll(poll, publishAt)
+}
+
+// createPoll attaches the poll to the post or message in owner, which is
+// pollOwnerPost or pollOwnerMessage.
+func createPoll(ctx context.Context, tx *sql.Tx, owner, ownerId string, poll *PollRequest, closesAt *time.Time) error {
+	var pollId string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO polls (`+owner+`, question, multiple, anonymous, closes_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;
+	`, ownerId, poll.Question, poll.Multiple, poll.Anonymous, closesAt).Scan(&pollId)
+	if err != nil {
+		return err
+	}
+
+	args := make([]any, 1, 2*len(poll.Options)+1)
+	args[0] = pollId
+	queries := make([]string, 0, len(poll.Options))
+
+	for i, o := range poll.Options {
+		queries = append(queries, fmt.Sprintf("($1, $%d, $%d)", 2*i+2, 2*i+3))
+		args = append(args, i, o)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO poll_options (poll_id, position, text) VALUES
+	`+strings.Join(queries, ", ")+";", args...)
+
+	return err
+}
+
+const pollQuery = `
+	SELECT pl.%s, pl.id, pl.question, pl.multiple, pl.anonymous, pl.closes_at,
+		pl.closes_at IS NOT NULL AND pl.closes_at <= Now() AS is_closed,
+		(SELECT COUNT(DISTINCT v.user_id) FROM poll_votes AS v WHERE v.poll_id = pl.id) AS voters,
+		po.options
+	FROM polls AS pl
+	LEFT JOIN LATERAL (
+		SELECT jsonb_agg(jsonb_build_object(
+			'id', id,
+			'text', text,
+			'votes', votes,
+			'voted', voted
+		) ORDER BY position) AS options
+		FROM (
+			SELECT o.id, o.text, o.position, COUNT(v.user_id) AS votes,
+				COALESCE(bool_or(v.user_id = $2), FALSE) AS voted
+			FROM poll_options AS o
+			LEFT JOIN poll_votes AS v ON o.id = v.option_id
+			WHERE o.poll_id = pl.id
+			GROUP BY o.id
+		) oc
+	) po ON TRUE
+	WHERE %s;
+`
+
+// getPolls returns the results of the polls as seen by userId, keyed by
+// the id of the post or message in owner they are attached to.
+func getPolls(ctx context.Context, userId, owner string, ids []string) (map[string]*Poll, error) {
+	result := make(map[string]*Poll)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	rows, err := db.Client.QueryContext(ctx,
+		fmt.Sprintf(pollQuery, owner, "pl."+owner+" = ANY($1)"),
+		pq.Array(ids), ToNullString(&userId),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ownerId string
+		var p Poll
+		if err := scanner.Scan(rows, &ownerId, &p); err != nil {
+			return nil, err
+		}
+		result[ownerId] = &p
+	}
+
+	return result, rows.Err()
+}
+
+func getPollById(ctx context.Context, pollId, userId string) (*Poll, error) {
+	var id string
+	var p Poll
+	row := db.Client.QueryRowContext(ctx,
+		fmt.Sprintf(pollQuery, "id", "pl.id = $1"),
+		pollId, ToNullString(&userId),
+	)
+
+	err := scanner.Scan(row, &id, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func attachPostPolls(ctx context.Context, userId string, posts []PostsResult) error {
+	ids := make([]string, 0)
+	for _, p := range posts {
+		if !p.IsDeleted {
+			ids = append(ids, p.Id)
+		}
+	}
+
+	polls, err := getPolls(ctx, userId, pollOwnerPost, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range posts {
+		if !posts[i].IsDeleted {
+			posts[i].Poll = polls[posts[i].Id]
+		}
+	}
+	return nil
+}
+
+func attachMessagePolls(ctx context.Context, userId string, messages []Message) error {
+	ids := make([]string, 0)
+	for _, m := range messages {
+		if m.IsDeleted != 1 && m.Kind == "user" {
+			ids = append(ids, m.Id)
+		}
+	}
+
+	polls, err := getPolls(ctx, userId, pollOwnerMessage, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if messages[i].IsDeleted != 1 {
+			messages[i].Poll = polls[messages[i].Id]
+		}
+	}
+	return nil
+}
+
+type pollAccess struct {
+	Anonymous, Multiple, IsClosed bool
+	AuthorId                      *string
+	ConversationId                *string
+	MessageId                     *string
+}
+
+// checkPollAccess makes sure userId can see the poll: its post is
+// published and not deleted, or they are in the conversation of its message.
+func checkPollAccess(ctx context.Context, pollId, userId string) (*pollAccess, error) {
+	var a pollAccess
+	row := db.Client.QueryRowContext(ctx, `
+		SELECT pl.anonymous, pl.multiple, pl.closes_at IS NOT NULL AND pl.closes_at <= Now(),
+			COALESCE(p.user_id, m.user_id), m.conversation_id, m.id
+		FROM polls AS pl
+		LEFT JOIN posts AS p ON pl.post_id = p.id AND p.is_deleted = FALSE AND p.scheduled_at IS NULL
+		LEFT JOIN messages AS m ON pl.message_id = m.id AND m.is_deleted != 1 AND m.scheduled_at IS NULL
+			AND `+messageNotExpired+`
+		WHERE pl.id = $1 AND (p.id IS NOT NULL OR m.id IS NOT NULL);
+	`, pollId)
+
+	err := scanner.Scan(row, &a)
+	if err == sql.ErrNoRows {
+		return nil, ErrForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if a.ConversationId != nil && !isParticipant(ctx, *a.ConversationId, userId) {
+		return nil, ErrForbidden
+	}
+
+	return &a, nil
+}
+
+// checkCanVote additionally makes sure the poll is still open and its
+// author has not blocked userId.
+func checkCanVote(ctx context.Context, pollId, userId string) (*pollAccess, error) {
+	a, err := checkPollAccess(ctx, pollId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.IsClosed {
+		return nil, ErrPollClosed
+	}
+
+	if a.AuthorId != nil && *a.AuthorId != userId {
+		isBlocked, err := IsUserBlocked(ctx, *a.AuthorId, userId)
+		if err != nil {
+			return nil, err
+		}
+		if isBlocked {
+			return nil, ErrBlocked
+		}
+	}
+
+	return a, nil
+}
+
+type VoteRequest struct {
+	OptionIds []string `json:"optionIds"`
+}
+
+// Vote replaces the votes of userId in the poll with the given options.
+func Vote(ctx context.Context, pollId, userId string, params *VoteRequest) (*Poll, error) {
+	ctx, span := tracing.Start(ctx, "services.Vote")
+	defer span.End()
+
+	options := slices.Clone(params.OptionIds)
+	slices.Sort(options)
+	options = slices.Compact(options)
+	if len(options) == 0 {
+		return nil, ErrWrongData
+	}
+
+	a, err := checkCanVote(ctx, pollId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.Multiple && len(options) != 1 {
+		return nil, ErrWrongData
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// votes on the poll are serialized, otherwise two concurrent votes
+	// could both replace nothing and leave two votes on a single choice poll
+	_, err = tx.ExecContext(ctx, `
+		SELECT id FROM polls WHERE id = $1 FOR UPDATE;
+	`, pollId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM poll_votes
+		WHERE poll_id = $1 AND user_id = $2;
+	`, pollId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the poll is checked again so that no vote slips in after it closed
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO poll_votes (poll_id, option_id, user_id)
+		SELECT o.poll_id, o.id, $3
+		FROM poll_options AS o
+		INNER JOIN polls AS pl ON o.poll_id = pl.id
+		WHERE o.poll_id = $1 AND o.id = ANY($2) AND (pl.closes_at IS NULL OR pl.closes_at > Now());
+	`, pollId, pq.Array(options), userId)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) != len(options) {
+		return nil, ErrWrongData
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	publishPollVote(ctx, pollId, a)
+	return getPollById(ctx, pollId, userId)
+}
+
+func RetractVote(ctx context.Context, pollId, userId string) (*Poll, error) {
+	ctx, span := tracing.Start(ctx, "services.RetractVote")
+	defer span.End()
+
+	a, err := checkCanVote(ctx, pollId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.Client.ExecContext(ctx, `
+		DELETE FROM poll_votes AS v
+		USING polls AS pl
+		WHERE v.poll_id = pl.id AND v.poll_id = $1 AND v.user_id = $2
+			AND (pl.closes_at IS NULL OR pl.closes_at > Now());
+	`, pollId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n != 0 {
+		publishPollVote(ctx, pollId, a)
+	}
+	return getPollById(ctx, pollId, userId)
+}
+
+type pollVoteEvent struct {
+	ConversationId string `json:"conversationId"`
+	MessageId      string `json:"messageId"`
+	PollId         string `json:"pollId"`
+}
+
+// publishPollVote tells the participants to refresh the results of a
+// poll in a message. Post polls are refreshed along with the feed.
+func publishPollVote(ctx context.Context, pollId string, a *pollAccess) {
+	if a.ConversationId == nil {
+		return
+	}
+
+	participants, err := getParticipantIds(ctx, *a.ConversationId)
+	if err != nil {
+		return
+	}
+	realtime.Publish(participants, realtime.Event{Type: "poll_vote", Data: pollVoteEvent{
+		ConversationId: *a.ConversationId, MessageId: *a.MessageId, PollId: pollId,
+	}})
+}
+
+func checkCanSeeVoters(ctx context.Context, convId, userId string) error {
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return err
+	}
+	if c.ConvType != "channel" {
+		return nil
+	}
+
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+	if !roleAllows(role, PermissionAdmins) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+type PollVoters struct {
+	OptionId string        `json:"optionId"`
+	Users    []MessageUser `json:"users"`
+}
+
+// GetPollVoters lists who voted for each option. Voters of anonymous
+// polls are never revealed, and in channels only admins see them since
+// subscribers are hidden from each other.
+func GetPollVoters(ctx context.Context, pollId, userId string) ([]PollVoters, error) {
+	ctx, span := tracing.Start(ctx, "services.GetPollVoters")
+	defer span.End()
+
+	a, err := checkPollAccess(ctx, pollId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if a.Anonymous {
+		return nil, ErrForbidden
+	}
+
+	if a.ConversationId != nil {
+		err = checkCanSeeVoters(ctx, *a.ConversationId, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT o.id, u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted
+		FROM poll_options AS o
+		INNER JOIN poll_votes AS v ON o.id = v.option_id
+		INNER JOIN users AS u ON v.user_id = u.id
+		WHERE o.poll_id = $1
+		ORDER BY o.position, v.created_at;
+	`, pollId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]PollVoters, 0)
+	for rows.Next() {
+		var optionId string
+		var u MessageUser
+		if err := scanner.Scan(rows, &optionId, &u); err != nil {
+			return nil, err
+		}
+
+		if len(result) == 0 || result[len(result)-1].OptionId != optionId {
+			result = append(result, PollVoters{OptionId: optionId, Users: make([]MessageUser, 0)})
+		}
+		last := &result[len(result)-1]
+		last.Users = append(last.Users, u)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	ReactionLike    = "like"
+	ReactionDislike = "dislike"
+	// defaultPostReactions is used when POST_REACTIONS is not set.
+	defaultPostReactions         = "❤️,😂,😮,😢,😡"
+	POST_REACTION_USERS_PER_PAGE = 20
+)
+
+// PostReactionTypes returns the reactions posts accept: like and dislike
+// followed by the emoji from the comma separated POST_REACTIONS variable.
+func PostReactionTypes() []string {
+	list, ok := os.LookupEnv("POST_REACTIONS")
+	if !ok {
+		list = defaultPostReactions
+	}
+
+	result := []string{ReactionLike, ReactionDislike}
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if isEmoji(e) && !slices.Contains(result, e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+type PostReactionCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+type PostReactionCounts []PostReactionCount
+
+func (r *PostReactionCounts) Scan(src any) error {
+	return scanJson(src, r)
+}
+
+// TogglePostReaction sets the reaction of userId to the post, or removes
+// it when it is the one they already have.
+func TogglePostReaction(ctx context.Context, userId, postId, reactionType string) error {
+	ctx, span := tracing.Start(ctx, "services.TogglePostReaction")
+	defer span.End()
+
+	if !slices.Contains(PostReactionTypes(), reactionType) {
+		return ErrInvalidEmoji
+	}
+
+	_, err := checkPostAvailable(ctx, postId, userId)
+	if err != nil {
+		return err
+	}
+
+	// each statement is atomic on its own, so concurrent toggles never
+	// fail on the key: the same reaction is removed, anything else is
+	// inserted or replaces the current one
+	res, err := db.Client.ExecContext(ctx, `
+		DELETE FROM post_reactions
+		WHERE post_id = $1 AND user_id = $2 AND type = $3;
+	`, postId, userId, reactionType)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n != 0 {
+		metrics.Reactions.WithLabelValues("post", "remove").Inc()
+		return nil
+	}
+
+	var inserted bool
+	err = db.Client.QueryRowContext(ctx, `
+		INSERT INTO post_reactions (type, post_id, user_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (post_id, user_id) DO UPDATE SET type = EXCLUDED.type
+		RETURNING xmax = 0;
+	`, reactionType, postId, userId).Scan(&inserted)
+	if err != nil {
+		return err
+	}
+
+	if inserted {
+		metrics.Reactions.WithLabelValues("post", "add").Inc()
+	} else {
+		metrics.Reactions.WithLabelValues("post", "change").Inc()
+	}
+	return nil
+}
+
+func RemovePostReaction(ctx context.Context, userId, postId string) error {
+	ctx, span := tracing.Start(ctx, "services.RemovePostReaction")
+	defer span.End()
+
+	result, err := db.Client.ExecContext(ctx, `
+		DELETE FROM post_reactions
+		WHERE post_id = $1 AND user_id = $2;
+	`, postId, userId)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n != 0 {
+		metrics.Reactions.WithLabelValues("post", "remove").Inc()
+	}
+	return nil
+}
+
+type PostReactionUser struct {
+	Type      string       `json:"type"`
+	CreatedAt string       `json:"createdAt"`
+	User      *MessageUser `json:"user"`
+}
+
+type PostReactionUsers struct {
+	Users   []PostReactionUser `json:"users"`
+	HasMore bool               `json:"hasMore"`
+}
+
+// GetPostReactions lists who reacted to the post with reactionType, or
+// with anything when it is empty, newest first. Users who blocked userId
+// or were blocked by them are left out.
+func GetPostReactions(ctx context.Context, postId, userId, reactionType string, page int) (*PostReactionUsers, error) {
+	ctx, span := tracing.Start(ctx, "services.GetPostReactions")
+	defer span.End()
+
+	var exists bool
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT true
+		FROM posts
+		WHERE id = $1 AND is_deleted = FALSE AND scheduled_at IS NULL;
+	`, postId).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, ErrForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT r.type, r.created_at, u.id, u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted
+		FROM post_reactions AS r
+		INNER JOIN users AS u ON r.user_id = u.id
+		WHERE r.post_id = $1 AND ($2 = '' OR r.type = $2) AND u.is_deleted = FALSE
+			AND NOT EXISTS (
+				SELECT 1
+				FROM blocked_users AS b
+				WHERE b.user_id = $3 AND b.blocked_user_id = r.user_id
+					OR b.user_id = r.user_id AND b.blocked_user_id = $3
+			)
+		ORDER BY r.created_at DESC, r.user_id
+		LIMIT $4 OFFSET $5;
+	`, postId, reactionType, ToNullString(&userId),
+		POST_REACTION_USERS_PER_PAGE+1, POST_REACTION_USERS_PER_PAGE*(page-1))
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := scanner.ScanRows(make([]PostReactionUser, 0), rows)
+	if err != nil {
+		return nil, err
+	}
+
+	result := PostReactionUsers{Users: users}
+	if len(users) > POST_REACTION_USERS_PER_PAGE {
+		result.Users = users[:POST_REACTION_USERS_PER_PAGE]
+		result.HasMore = true
+	}
+
+	return &result, nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/tracing"
 )
 
 const POSTS_PER_PAGE = 10
@@ -31,15 +34,20 @@ type PostMedia struct {
 }
 
 type PostParams struct {
-	Text         string      `json:"text"`
-	OriginalId   *string     `json:"originalId"`
-	CommentToId  *string     `json:"commentToId"`
-	ResponseToId *string     `json:"responseToId"`
-	CanComment   bool        `json:"canComment"`
-	Media        []PostMedia `json:"media"`
+	Text         string       `json:"text"`
+	OriginalId   *string      `json:"originalId"`
+	CommentToId  *string      `json:"commentToId"`
+	ResponseToId *string      `json:"responseToId"`
+	CanComment   bool         `json:"canComment"`
+	Media        []PostMedia  `json:"media"`
+	ScheduledAt  *string      `json:"scheduledAt"`
+	Poll         *PollRequest `json:"poll"`
 }
 
-func AddMedia(tx *sql.Tx, postId string, media []PostMedia) error {
+func AddMedia(ctx context.Context, tx *sql.Tx, postId string, media []PostMedia) error {
+	ctx, span := tracing.Start(ctx, "services.AddMedia")
+	defer span.End()
+
 	args := make([]any, 0, len(media))
 	query := ""
 
@@ -52,7 +60,7 @@ func AddMedia(tx *sql.Tx, postId string, media []PostMedia) error {
 
 	query = query[:len(query)-2]
 
-	_, err := tx.Exec(`
+	_, err := tx.ExecContext(ctx, `
 		INSERT INTO post_media (post_id, id, url, url_modifiers, type, mime, subtype, height, width) VALUES
 	`+query+`
 		ON CONFLICT (id) DO NOTHING;
@@ -61,55 +69,67 @@ func AddMedia(tx *sql.Tx, postId string, media []PostMedia) error {
 	return err
 }
 
-func CreatePost(userId string, params *PostParams) (string, error) {
-	if params.CommentToId != nil {
-		var originalUserId string
-		err := db.Client.QueryRow(`
-			SELECT u.id
-			FROM posts AS p
-			LEFT JOIN users AS u ON p.user_id = u.id
-			WHERE p.id = $1
-		`, params.CommentToId).Scan(&originalUserId)
-		if err != nil {
-			return "", err
-		}
-		isBlocked, err := IsUserBlocked(originalUserId, userId)
+func CreatePost(ctx context.Context, userId string, params *PostParams) (string, error) {
+	ctx, span := tracing.Start(ctx, "services.CreatePost")
+	defer span.End()
+
+	err := checkPostTargets(ctx, userId, params.CommentToId, params.OriginalId)
+	if err != nil {
+		return "", err
+	}
+
+	scheduledAt, err := parseScheduledAt(params.ScheduledAt)
+	if err != nil {
+		return "", err
+	}
+
+	var pollClosesAt *time.Time
+	if params.Poll != nil {
+		pollClosesAt, err = validatePoll(params.Poll, scheduledAt)
 		if err != nil {
 			return "", err
 		}
-		if isBlocked {
-			return "", ErrBlocked
-		}
 	}
 
 	var postId string
 
-	tx, err := db.Client.Begin()
+	tx, err := db.Client.BeginTx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
 	defer tx.Rollback()
 
-	err = tx.QueryRow(`
-		INSERT INTO posts (text, user_id, original_id, comment_to_id, response_to_id, can_comment)
-		VALUES ($1, $2, $3, $4, $5, $6)
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO posts (text, user_id, original_id, comment_to_id, response_to_id, can_comment, scheduled_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id;
 	`, params.Text, userId, ToNullString(params.OriginalId),
-		ToNullString(params.CommentToId), ToNullString(params.ResponseToId), params.CanComment,
+		ToNullString(params.CommentToId), ToNullString(params.ResponseToId), params.CanComment, scheduledAt,
 	).Scan(&postId)
 
 	if err != nil {
 		return "", err
 	}
 
-	if len(params.Media) == 0 {
-		err = tx.Commit()
-		return postId, err
+	if len(params.Media) != 0 {
+		err = AddMedia(ctx, tx, postId, params.Media)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	err = AddMedia(tx, postId, params.Media)
-	if err != nil {
-		return "", err
+	if params.Poll != nil {
+		err = createPoll(ctx, tx, pollOwnerPost, postId, params.Poll, pollClosesAt)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if scheduledAt != nil {
+		err = enqueuePublish(ctx, tx, publishPostKind, postId, *scheduledAt)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	err = tx.Commit()
@@ -117,13 +137,69 @@ func CreatePost(userId string, params *PostParams) (string, error) {
 		return "", err
 	}
 
+	if scheduledAt == nil {
+		metrics.PostsCreated.Inc()
+	}
 	return postId, nil
 }
 
-func GetPostById(id string) (*Post, error) {
+// checkPostTargets makes sure userId may comment on commentToId and
+// repost originalId, whichever are set. Scheduled posts run it again when
+// they are published.
+func checkPostTargets(ctx context.Context, userId string, commentToId, originalId *string) error {
+	if commentToId != nil {
+		err := checkCanComment(ctx, *commentToId, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if originalId != nil {
+		_, err := checkPostAvailable(ctx, *originalId, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkCanComment makes sure userId may comment on the post: its author
+// has not blocked them and comments are enabled, unless it is their own.
+func checkCanComment(ctx context.Context, postId, userId string) error {
+	var authorId string
+	var canComment bool
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT user_id, can_comment
+		FROM posts
+		WHERE id = $1 AND is_deleted = FALSE;
+	`, postId).Scan(&authorId, &canComment)
+	if err != nil {
+		return err
+	}
+
+	isBlocked, err := IsUserBlocked(ctx, authorId, userId)
+	if err != nil {
+		return err
+	}
+	if isBlocked {
+		return ErrBlocked
+	}
+
+	if !canComment && authorId != userId {
+		return ErrCommentsDisabled
+	}
+
+	return nil
+}
+
+func GetPostById(ctx context.Context, id string) (*Post, error) {
+	ctx, span := tracing.Start(ctx, "services.GetPostById")
+	defer span.End()
+
 	var post Post
 
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT id, user_id, text, original_id, created_at, updated_at
 		FROM posts
 		WHERE id = $1;
@@ -144,14 +220,17 @@ type MediaFull struct {
 	PostId    string    `json:"postId"`
 }
 
-func GetPostMedia(id string, all bool) ([]MediaFull, error) {
+func GetPostMedia(ctx context.Context, id string, all bool) ([]MediaFull, error) {
+	ctx, span := tracing.Start(ctx, "services.GetPostMedia")
+	defer span.End()
+
 	result := make([]MediaFull, 0)
 
 	query := "SELECT * FROM post_media WHERE post_id = $1"
 	if !all {
 		query += " AND is_deleted = FALSE;"
 	}
-	rows, err := db.Client.Query(query, id)
+	rows, err := db.Client.QueryContext(ctx, query, id)
 
 	if err != nil {
 		return nil, err
@@ -180,7 +259,10 @@ type PostUpdateRequest struct {
 	CanComment *bool       `json:"canComment"`
 }
 
-func UpdatePost(id string, post *PostUpdateRequest) error {
+func UpdatePost(ctx context.Context, id string, post *PostUpdateRequest) error {
+	ctx, span := tracing.Start(ctx, "services.UpdatePost")
+	defer span.End()
+
 	queries := make([]string, 0)
 	args := make([]any, 0)
 	argsCount := 1
@@ -197,7 +279,7 @@ func UpdatePost(id string, post *PostUpdateRequest) error {
 		argsCount++
 	}
 
-	tx, err := db.Client.Begin()
+	tx, err := db.Client.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -207,7 +289,7 @@ func UpdatePost(id string, post *PostUpdateRequest) error {
 		postMediaMap := make(map[string]bool)
 		toDelete := make([]string, 0)
 
-		media, err := GetPostMedia(id, false)
+		media, err := GetPostMedia(ctx, id, false)
 		if err != nil {
 			return err
 		}
@@ -223,7 +305,7 @@ func UpdatePost(id string, post *PostUpdateRequest) error {
 		}
 
 		if len(toDelete) != 0 {
-			_, err = tx.Exec(
+			_, err = tx.ExecContext(ctx,
 				fmt.Sprintf("UPDATE post_media SET is_deleted = TRUE WHERE url IN (%v);", strings.Join(toDelete, ", ")),
 			)
 			if err != nil {
@@ -232,7 +314,7 @@ func UpdatePost(id string, post *PostUpdateRequest) error {
 		}
 
 		if len(post.Media) != 0 {
-			err = AddMedia(tx, id, post.Media)
+			err = AddMedia(ctx, tx, id, post.Media)
 			if err != nil {
 				return err
 			}
@@ -241,7 +323,7 @@ func UpdatePost(id string, post *PostUpdateRequest) error {
 
 	args = append(args, id)
 
-	_, err = tx.Exec(
+	_, err = tx.ExecContext(ctx,
 		"UPDATE posts SET\n"+strings.Join(queries, ", ")+fmt.Sprintf("\nWHERE id = $%d", argsCount),
 		args...,
 	)
@@ -269,24 +351,29 @@ type postBase struct {
 }
 
 type postInfo struct {
-	CanComment   bool    `json:"canComment"`
-	IsDeleted    bool    `json:"isDeleted"`
-	OriginalId   *string `json:"originalId,omitempty"`
-	CommentToId  *string `json:"commentToId,omitempty"`
-	ResponseToId *string `json:"responseToId,omitempty"`
-	Likes        int     `json:"likes"`
-	Dislikes     int     `json:"dislikes"`
-	Reaction     int     `json:"reaction"`
-	Comments     int     `json:"comments"`
-	Responses    int     `json:"responseCount"`
-	Reposts      int     `json:"reposts"`
-	IsFavorite   bool    `json:"isFavorite"`
+	CanComment   bool               `json:"canComment"`
+	IsDeleted    bool               `json:"isDeleted"`
+	OriginalId   *string            `json:"originalId,omitempty"`
+	CommentToId  *string            `json:"commentToId,omitempty"`
+	ResponseToId *string            `json:"responseToId,omitempty"`
+	Likes        int                `json:"likes"`
+	Dislikes     int                `json:"dislikes"`
+	Reaction     int                `json:"reaction"`
+	Reactions    PostReactionCounts `json:"reactions"`
+	MyReaction   *string            `json:"myReaction"`
+	Comments     int                `json:"comments"`
+	Responses    int                `json:"responseCount"`
+	Reposts      int                `json:"reposts"`
+	Shares       int                `json:"shares"`
+	IsFavorite   bool               `json:"isFavorite"`
+	ScheduledAt  *string            `json:"scheduledAt,omitempty"`
 }
 
 type PostsResult struct {
 	postBase
 	postInfo
 	Media []PostMedia `json:"media"`
+	Poll  *Poll       `json:"poll,omitempty"`
 }
 
 type TSortBy int
@@ -318,37 +405,59 @@ func buildPostQuery(params *QueryParams) (string, []interface{}) {
 	args := []interface{}{userId}
 
 	query := `
-		SELECT p.id, p.text, p.created_at, p.updated_at, p.can_comment, p.is_deleted,
+		SELECT p.id, p.text, p.created_at, p.updated_at, p.can_comment, p.is_deleted, p.scheduled_at,
 			u.id as "userId", u.username, u.name, u.avatar_url, u.avatar_type, u.is_deleted as user_deleted,
 			o.id as "originalId", c.id as "commentToId", r.id as "responseToId",
 			COALESCE(pr.likes, 0), COALESCE(pr.dislikes, 0), COALESCE(pr.reaction, 0),
 			COUNT(pc.id) as comments, COUNT(post_r.id) as responses, COALESCE(reposts.count, 0),
+			COALESCE(shares.count, 0),
 			CASE WHEN fp.post_id IS NOT NULL THEN TRUE ELSE FALSE END as favorite,
-			m.media
+			m.media, prs.reactions, pr.my_reaction
 		FROM posts as p
 		LEFT JOIN users as u ON p.user_id = u.id
 		LEFT JOIN posts as o ON p.original_id = o.id
 		LEFT JOIN posts as c ON p.comment_to_id = c.id
 		LEFT JOIN posts as r ON p.response_to_id = r.id
-		LEFT JOIN posts as pc ON p.id = pc.comment_to_id
-		LEFT JOIN posts as post_r ON p.id = post_r.response_to_id
+		LEFT JOIN posts as pc ON p.id = pc.comment_to_id AND pc.scheduled_at IS NULL
+		LEFT JOIN posts as post_r ON p.id = post_r.response_to_id AND post_r.scheduled_at IS NULL
 		LEFT JOIN (
 			SELECT original_id, COUNT(id) as count
 			FROM posts
+			WHERE scheduled_at IS NULL
 			GROUP BY original_id
 		) reposts ON reposts.original_id = p.id
+		LEFT JOIN (
+			SELECT post_id, COUNT(id) as count
+			FROM messages
+			WHERE post_id IS NOT NULL AND is_deleted != 1 AND scheduled_at IS NULL
+			GROUP BY post_id
+		) shares ON shares.post_id = p.id
 		LEFT JOIN (
 			SELECT post_id,
-				SUM(case when liked = true then 1 else 0 end) AS likes,
-				SUM(case when liked = false then 1 else 0 end) AS dislikes,
+				SUM(case when type = 'like' then 1 else 0 end) AS likes,
+				SUM(case when type = 'dislike' then 1 else 0 end) AS dislikes,
 				SUM(case
 					when user_id != $1 then 0
-					when liked = true then 1
-					when liked = false then -1 
-				end) AS reaction
+					when type = 'like' then 1
+					when type = 'dislike' then -1
+					else 0
+				end) AS reaction,
+				MAX(case when user_id = $1 then type end) AS my_reaction
 			FROM post_reactions
 			GROUP BY post_id
 		) pr ON p.id = pr.post_id
+		LEFT JOIN (
+			SELECT post_id, jsonb_agg(jsonb_build_object(
+				'type', type,
+				'count', count
+			) ORDER BY count DESC, type) AS reactions
+			FROM (
+				SELECT post_id, type, COUNT(*) AS count
+				FROM post_reactions
+				GROUP BY post_id, type
+			) prc
+			GROUP BY post_id
+		) prs ON p.id = prs.post_id
 		LEFT JOIN favorite_posts as fp ON p.id = fp.post_id AND fp.user_id = $1
 		LEFT JOIN (
 			SELECT post_id, jsonb_agg(jsonb_build_object(
@@ -394,8 +503,11 @@ func buildPostQuery(params *QueryParams) (string, []interface{}) {
 		query += "\nWHERE p.comment_to_id IS NULL AND p.is_deleted = FALSE\n"
 	}
 
+	// scheduled posts are only visible to their author until published
+	query += "AND (p.scheduled_at IS NULL OR p.user_id = $1)\n"
+
 	query += `
-		GROUP BY p.id, u.id, o.id, c.id, r.id, pr.likes, pr.dislikes, pr.reaction, reposts.count, fp.post_id, m.media
+		GROUP BY p.id, u.id, o.id, c.id, r.id, pr.likes, pr.dislikes, pr.reaction, pr.my_reaction, prs.reactions, reposts.count, shares.count, fp.post_id, m.media
 	`
 
 	switch params.OrderBy {
@@ -417,7 +529,7 @@ func buildPostQuery(params *QueryParams) (string, []interface{}) {
 	return query, args
 }
 
-func parsePosts(rows *sql.Rows) ([]PostsResult, error) {
+func parsePosts(ctx context.Context, rows *sql.Rows, userId string) ([]PostsResult, error) {
 	result := make([]PostsResult, 0)
 	for rows.Next() {
 		var text, updatedAt string
@@ -425,17 +537,21 @@ func parsePosts(rows *sql.Rows) ([]PostsResult, error) {
 		row := PostsResult{}
 
 		err := rows.Scan(
-			&row.Id, &text, &row.CreatedAt, &updatedAt, &row.CanComment, &row.IsDeleted,
+			&row.Id, &text, &row.CreatedAt, &updatedAt, &row.CanComment, &row.IsDeleted, &row.ScheduledAt,
 			&userId, &username, &name, &avatarUrl, &avatarType, &row.User.IsDeleted,
 			&row.OriginalId, &row.CommentToId, &row.ResponseToId,
-			&row.Likes, &row.Dislikes, &row.Reaction, &row.Comments, &row.Responses, &row.Reposts, &row.IsFavorite,
-			&mediaJson,
+			&row.Likes, &row.Dislikes, &row.Reaction, &row.Comments, &row.Responses, &row.Reposts, &row.Shares, &row.IsFavorite,
+			&mediaJson, &row.Reactions, &row.MyReaction,
 		)
 
 		if err != nil {
 			return nil, err
 		}
 
+		if row.Reactions == nil {
+			row.Reactions = PostReactionCounts{}
+		}
+
 		if row.IsDeleted {
 			result = append(result, row)
 			continue
@@ -470,12 +586,21 @@ func parsePosts(rows *sql.Rows) ([]PostsResult, error) {
 		result = append(result, row)
 	}
 
+	err := attachPostPolls(ctx, userId, result)
+	if err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 
-func GetPosts(params *QueryParams) ([]PostsResult, error) {
+func GetPosts(ctx context.Context, params *QueryParams) ([]PostsResult, error) {
+	ctx, span := tracing.Start(ctx, "services.GetPosts")
+	defer span.End()
+	defer metrics.TimeQuery("build_post_query")()
+
 	query, args := buildPostQuery(params)
-	rows, err := db.Client.Query(query, args...)
+	rows, err := db.Client.QueryContext(ctx, query, args...)
 
 	if err != nil {
 		return nil, err
@@ -483,57 +608,34 @@ func GetPosts(params *QueryParams) ([]PostsResult, error) {
 
 	defer rows.Close()
 
-	result, err := parsePosts(rows)
+	result, err := parsePosts(ctx, rows, params.RequestUserId)
 	if err != nil {
 		return nil, err
 	}
 	return result, err
 }
 
-type PostReaction struct {
-	Liked          bool
-	PostId, UserId string
-}
+// ProcessReaction toggles a like or dislike, kept for clients that do not
+// know about the other reactions yet.
+func ProcessReaction(ctx context.Context, userId, postId string, liked bool) error {
+	ctx, span := tracing.Start(ctx, "services.ProcessReaction")
+	defer span.End()
 
-func ProcessReaction(userId, postId string, liked bool) error {
-	var r PostReaction
-	err := db.Client.QueryRow(`
-		SELECT liked, post_id, user_id
-		FROM post_reactions
-		WHERE user_id = $1 AND post_id = $2;
-	`, userId, postId).Scan(&r.Liked, &r.PostId, &r.UserId)
-
-	if err == sql.ErrNoRows {
-		_, err = db.Client.Exec(`
-			INSERT INTO post_reactions (liked, post_id, user_id)
-			VALUES ($1, $2, $3);
-		`, liked, postId, userId)
-		return err
-	}
-
-	if err != nil {
-		return err
+	reactionType := ReactionDislike
+	if liked {
+		reactionType = ReactionLike
 	}
 
-	if r.Liked != liked {
-		_, err := db.Client.Exec(`
-			UPDATE post_reactions SET liked = $1 
-			WHERE post_id = $2 AND user_id = $3;
-		`, liked, postId, userId)
-		return err
-	}
-
-	_, err = db.Client.Exec(`
-		DELETE FROM post_reactions
-		WHERE post_id = $1 AND user_id = $2;
-	`, postId, userId)
-
-	return err
+	return TogglePostReaction(ctx, userId, postId, reactionType)
 }
 
-func QueryPostById(postId, userId string) (*PostsResult, error) {
+func QueryPostById(ctx context.Context, postId, userId string) (*PostsResult, error) {
+	ctx, span := tracing.Start(ctx, "services.QueryPostById")
+	defer span.End()
+	defer metrics.TimeQuery("build_post_query")()
+
 	query, args := buildPostQuery(&QueryParams{PostId: postId, RequestUserId: userId})
-	rows, err := db.Client.Query(query, args...)
+	rows, err := db.Client.QueryContext(ctx, query, args...)
 
 	if err != nil {
 		return nil, err
@@ -541,7 +643,7 @@ func QueryPostById(postId, userId string) (*PostsResult, error) {
 
 	defer rows.Close()
 
-	result, err := parsePosts(rows)
+	result, err := parsePosts(ctx, rows, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -553,10 +655,14 @@ func QueryPostById(postId, userId string) (*PostsResult, error) {
 	return &result[0], nil
 }
 
-func HasMorePosts(page int) (bool, error) {
+func HasMorePosts(ctx context.Context, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasMorePosts")
+	defer span.End()
+	defer metrics.TimeQuery("has_more_posts")()
+
 	var total int
-	err := db.Client.QueryRow(`
-		SELECT COUNT(*) FROM posts WHERE comment_to_id IS NULL AND is_deleted = FALSE;
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM posts WHERE comment_to_id IS NULL AND is_deleted = FALSE AND scheduled_at IS NULL;
 	`).Scan(&total)
 	if err != nil {
 		return false, err
@@ -564,11 +670,14 @@ func HasMorePosts(page int) (bool, error) {
 	return total > page*POSTS_PER_PAGE, nil
 }
 
-func CountComments(postId string, page int) (int, bool, error) {
+func CountComments(ctx context.Context, postId string, page int) (int, bool, error) {
+	ctx, span := tracing.Start(ctx, "services.CountComments")
+	defer span.End()
+
 	var total, filtered int
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT COUNT(*) AS total, COUNT(*) FILTER (WHERE response_to_id IS NULL) AS filtered 
-		FROM posts WHERE comment_to_id = $1;
+		FROM posts WHERE comment_to_id = $1 AND scheduled_at IS NULL;
 	`, postId).Scan(&total, &filtered)
 
 	if err != nil {
@@ -578,17 +687,23 @@ func CountComments(postId string, page int) (int, bool, error) {
 	return total, filtered > page*POSTS_PER_PAGE, nil
 }
 
-func CountResponses(commentId string, page int) (int, bool, error) {
+func CountResponses(ctx context.Context, commentId string, page int) (int, bool, error) {
+	ctx, span := tracing.Start(ctx, "services.CountResponses")
+	defer span.End()
+
 	var total int
-	err := db.Client.QueryRow(`SELECT COUNT(*) FROM posts WHERE response_to_id = $1;`, commentId).Scan(&total)
+	err := db.Client.QueryRowContext(ctx, `SELECT COUNT(*) FROM posts WHERE response_to_id = $1 AND scheduled_at IS NULL;`, commentId).Scan(&total)
 	if err != nil {
 		return 0, false, err
 	}
 	return total, total > page*POSTS_PER_PAGE, nil
 }
 
-func ProcessFavorite(postId, userId string) error {
-	result, err := db.Client.Exec(
+func ProcessFavorite(ctx context.Context, postId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.ProcessFavorite")
+	defer span.End()
+
+	result, err := db.Client.ExecContext(ctx,
 		"DELETE FROM favorite_posts WHERE post_id = $1 AND user_id = $2",
 		postId, userId)
 
@@ -605,34 +720,44 @@ func ProcessFavorite(postId, userId string) error {
 		return nil
 	}
 
-	_, err = db.Client.Exec(
+	_, err = db.Client.ExecContext(ctx,
 		"INSERT INTO favorite_posts (post_id, user_id) VALUES ($1, $2);",
 		postId, userId)
 
 	return err
 }
 
-func GetFavoritePosts(userId string, page int) ([]PostsResult, error) {
+func GetFavoritePosts(ctx context.Context, userId string, page int) ([]PostsResult, error) {
+	ctx, span := tracing.Start(ctx, "services.GetFavoritePosts")
+	defer span.End()
+	defer metrics.TimeQuery("build_post_query")()
+
 	query, args := buildPostQuery(&QueryParams{RequestUserId: userId, IsFavorite: true, Page: page, OrderBy: SortNew})
-	rows, err := db.Client.Query(query, args...)
+	rows, err := db.Client.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	return parsePosts(rows)
+	return parsePosts(ctx, rows, userId)
 }
 
-func HasMoreFavorite(userId string, page int) (bool, error) {
+func HasMoreFavorite(ctx context.Context, userId string, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasMoreFavorite")
+	defer span.End()
+
 	var total int
-	err := db.Client.QueryRow("SELECT COUNT(*) FROM favorite_posts WHERE user_id = $1;", userId).Scan(&total)
+	err := db.Client.QueryRowContext(ctx, "SELECT COUNT(*) FROM favorite_posts WHERE user_id = $1;", userId).Scan(&total)
 	if err != nil {
 		return false, err
 	}
 	return total > page*POSTS_PER_PAGE, nil
 }
 
-func DeletePost(postId, userId string) error {
-	_, err := db.Client.Exec(`
+func DeletePost(ctx context.Context, postId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.DeletePost")
+	defer span.End()
+
+	_, err := db.Client.ExecContext(ctx, `
 		UPDATE posts SET is_deleted = TRUE
 		WHERE id = $1 AND user_id = $2;
 	`, postId, userId)
@@ -652,8 +777,11 @@ type PostHistory struct {
 	Media   []MediaFull  `json:"media"`
 }
 
-func GetPostHistory(postId string) (*PostHistory, error) {
-	rows, err := db.Client.Query(`
+func GetPostHistory(ctx context.Context, postId string) (*PostHistory, error) {
+	ctx, span := tracing.Start(ctx, "services.GetPostHistory")
+	defer span.End()
+
+	rows, err := db.Client.QueryContext(ctx, `
 		SELECT id, created_at, text, is_deleted
 		FROM post_changes
 		WHERE post_id = $1;
@@ -674,7 +802,7 @@ func GetPostHistory(postId string) (*PostHistory, error) {
 		result.Changes = append(result.Changes, change)
 	}
 
-	result.Media, err = GetPostMedia(postId, true)
+	result.Media, err = GetPostMedia(ctx, postId, true)
 	if err != nil {
 		return nil, err
 	}
@@ -682,21 +810,28 @@ func GetPostHistory(postId string) (*PostHistory, error) {
 	return &result, nil
 }
 
-func SearchPosts(q string, page int, userId string) ([]PostsResult, error) {
+func SearchPosts(ctx context.Context, q string, page int, userId string) ([]PostsResult, error) {
+	ctx, span := tracing.Start(ctx, "services.SearchPosts")
+	defer span.End()
+	defer metrics.TimeQuery("build_post_query")()
+
 	query, args := buildPostQuery(&QueryParams{RequestUserId: userId, Search: q, Page: page, OrderBy: SortNew})
-	rows, err := db.Client.Query(query, args...)
+	rows, err := db.Client.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	return parsePosts(rows)
+	return parsePosts(ctx, rows, userId)
 }
 
-func HasSearchMorePosts(q string, page int) (bool, error) {
+func HasSearchMorePosts(ctx context.Context, q string, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasSearchMorePosts")
+	defer span.End()
+
 	var total int
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 			SELECT COUNT(*) FROM posts WHERE plainto_tsquery($1) @@ post_tsv 
-			AND is_deleted = FALSE;`,
+			AND is_deleted = FALSE AND scheduled_at IS NULL;`,
 		q).Scan(&total)
 	if err != nil {
 		return false, err
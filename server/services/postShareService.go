@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const MAX_SHARE_TARGETS = 20
+
+// checkPostAvailable makes sure the post is published and its author has
+// not blocked userId, and returns the author. It guards sharing, reposting
+// and reacting to the post.
+func checkPostAvailable(ctx context.Context, postId, userId string) (string, error) {
+	var authorId string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT user_id
+		FROM posts
+		WHERE id = $1 AND is_deleted = FALSE AND scheduled_at IS NULL;
+	`, postId).Scan(&authorId)
+	if err != nil {
+		return "", err
+	}
+
+	isBlocked, err := IsUserBlocked(ctx, authorId, userId)
+	if err != nil {
+		return "", err
+	}
+	if isBlocked {
+		return "", ErrBlocked
+	}
+
+	return authorId, nil
+}
+
+// checkPostRecipient rejects sharing a post into a conversation with
+// someone its author has blocked: the other user of a private conversation
+// or any active participant of a group or channel.
+func checkPostRecipient(ctx context.Context, authorId, convId, userId string) error {
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return err
+	}
+	if c.ConvType != "private" {
+		return checkPostGroup(ctx, authorId, convId, userId)
+	}
+
+	var receiver string
+	err = db.Client.QueryRowContext(ctx, `
+		SELECT user_id
+		FROM participants
+		WHERE conversation_id = $1 AND user_id != $2
+		LIMIT 1;
+	`, convId, userId).Scan(&receiver)
+	if err != nil {
+		return err
+	}
+
+	return checkPostReceiver(ctx, authorId, receiver)
+}
+
+func checkPostGroup(ctx context.Context, authorId, convId, userId string) error {
+	var hasBlocked bool
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM participants AS p
+			INNER JOIN blocked_users AS b ON b.blocked_user_id = p.user_id AND b.user_id = $1
+			WHERE p.conversation_id = $2 AND p.user_id != $3
+				AND p.has_left = false AND p.is_kicked = false
+		);
+	`, authorId, convId, userId).Scan(&hasBlocked)
+	if err != nil {
+		return err
+	}
+	if hasBlocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+func checkPostReceiver(ctx context.Context, authorId, receiverId string) error {
+	isBlocked, err := IsUserBlocked(ctx, authorId, receiverId)
+	if err != nil {
+		return err
+	}
+	if isBlocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+type SharePostRequest struct {
+	ConversationIds []string `json:"conversationIds"`
+	UserIds         []string `json:"userIds"`
+	Comment         *string  `json:"comment"`
+}
+
+type SharedMessage struct {
+	ConversationId string `json:"conversationId"`
+	MessageId      string `json:"messageId"`
+}
+
+// SharePost sends the post, with an optional comment, to every listed
+// conversation and to private conversations with the listed users, which
+// are created when missing. Either all of them get it or none.
+func SharePost(ctx context.Context, postId, userId string, params *SharePostRequest) ([]SharedMessage, error) {
+	ctx, span := tracing.Start(ctx, "services.SharePost")
+	defer span.End()
+
+	if n := len(params.ConversationIds) + len(params.UserIds); n == 0 || n > MAX_SHARE_TARGETS {
+		return nil, ErrWrongData
+	}
+
+	if params.Comment != nil {
+		comment := strings.TrimSpace(*params.Comment)
+		params.Comment = &comment
+		if comment == "" {
+			params.Comment = nil
+		}
+	}
+
+	authorId, err := checkPostAvailable(ctx, postId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	convIds := make([]string, 0, len(params.ConversationIds)+len(params.UserIds))
+	seen := make(map[string]bool)
+	addTarget := func(convId string) {
+		if !seen[convId] {
+			seen[convId] = true
+			convIds = append(convIds, convId)
+		}
+	}
+
+	for _, convId := range params.ConversationIds {
+		err = checkCanSendMessage(ctx, convId, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkPostRecipient(ctx, authorId, convId, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		addTarget(convId)
+	}
+
+	for _, receiverId := range params.UserIds {
+		err = checkPostReceiver(ctx, authorId, receiverId)
+		if err != nil {
+			return nil, err
+		}
+
+		convId, err := findPrivateConversation(ctx, userId, receiverId)
+		if err == sql.ErrNoRows {
+			convId, err = createConversation(ctx, tx, userId, &CreateConversationRequest{
+				ConvType:  "private",
+				AddUserId: receiverId,
+			})
+		} else if err == nil {
+			err = checkCanSendMessage(ctx, convId, userId)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		addTarget(convId)
+	}
+
+	result := make([]SharedMessage, 0, len(convIds))
+	events := make([]messageEvent, 0, len(convIds))
+
+	for _, convId := range convIds {
+		var id string
+		var createdAt time.Time
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO messages (user_id, conversation_id, text, post_id)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at;
+		`, userId, convId, ToNullString(params.Comment), postId).Scan(&id, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = markSentMessageRead(ctx, tx, convId, userId, id, createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, SharedMessage{ConversationId: convId, MessageId: id})
+		events = append(events, messageEvent{
+			ConversationId: convId,
+			MessageId:      id,
+			UserId:         userId,
+			Text:           params.Comment,
+			CreatedAt:      createdAt,
+		})
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.MessagesSent.Add(float64(len(events)))
+	for _, e := range events {
+		publishNotification(ctx, e)
+	}
+
+	return result, nil
+}
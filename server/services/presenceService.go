@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/presence"
+	"github.com/yura4ka/crickter/realtime"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const typingTimeout = 6 * time.Second
+
+var presenceStore presence.Store = presence.NewMemoryStore()
+
+func SetPresenceStore(store presence.Store) {
+	presenceStore = store
+}
+
+type Presence struct {
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+type presenceEvent struct {
+	UserId string `json:"userId"`
+	Presence
+}
+
+type typingEvent struct {
+	ConversationId string `json:"conversationId"`
+	UserId         string `json:"userId"`
+	IsTyping       bool   `json:"isTyping"`
+}
+
+// getContacts returns users that share at least one conversation with userId.
+func getContacts(ctx context.Context, userId string) ([]string, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT DISTINCT p2.user_id
+		FROM participants AS p1
+		INNER JOIN participants AS p2 ON p1.conversation_id = p2.conversation_id
+		INNER JOIN conversations AS c ON p1.conversation_id = c.id
+		WHERE p1.user_id = $1 AND p2.user_id != $1 AND c.is_deleted != 1
+			AND p1.has_left = false AND p1.is_kicked = false
+			AND p2.has_left = false AND p2.is_kicked = false;
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+
+	return result, rows.Err()
+}
+
+func getParticipantIds(ctx context.Context, convId string) ([]string, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT user_id
+		FROM participants
+		WHERE conversation_id = $1 AND has_left = false AND is_kicked = false;
+	`, convId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+
+	return result, rows.Err()
+}
+
+func without(ids []string, id string) []string {
+	result := make([]string, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func getPresence(ctx context.Context, userIds []string) (map[string]*Presence, error) {
+	result := make(map[string]*Presence, len(userIds))
+	if len(userIds) == 0 {
+		return result, nil
+	}
+
+	statuses, err := presenceStore.Statuses(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT u.id, u.hide_last_seen, ls.last_seen_at
+		FROM users AS u
+		LEFT JOIN user_last_seen AS ls ON u.id = ls.user_id
+		WHERE u.id = ANY($1) AND u.is_deleted = FALSE;
+	`, pq.Array(userIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var hideLastSeen bool
+		var lastSeen *time.Time
+		if err := rows.Scan(&id, &hideLastSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+
+		status := statuses[id]
+		p := &Presence{Online: status.Online}
+		if !p.Online && !hideLastSeen {
+			p.LastSeen = status.LastSeen
+			if p.LastSeen == nil {
+				p.LastSeen = lastSeen
+			}
+		}
+		result[id] = p
+	}
+
+	return result, rows.Err()
+}
+
+func attachPresence(ctx context.Context, users []*MessageUser) error {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		if u.Id != nil {
+			ids = append(ids, *u.Id)
+		}
+	}
+
+	statuses, err := getPresence(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if u.Id != nil {
+			u.Presence = statuses[*u.Id]
+		}
+	}
+	return nil
+}
+
+func publishPresence(ctx context.Context, userId string) error {
+	contacts, err := getContacts(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	p, err := getPresence(ctx, []string{userId})
+	if err != nil {
+		return err
+	}
+	if p[userId] == nil {
+		return nil
+	}
+
+	realtime.Publish(contacts, realtime.Event{
+		Type: "presence",
+		Data: presenceEvent{UserId: userId, Presence: *p[userId]},
+	})
+	return nil
+}
+
+func UserConnected(ctx context.Context, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.UserConnected")
+	defer span.End()
+
+	cameOnline, err := presenceStore.Connect(ctx, userId)
+	if err != nil || !cameOnline {
+		return err
+	}
+
+	return publishPresence(ctx, userId)
+}
+
+func UserDisconnected(ctx context.Context, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.UserDisconnected")
+	defer span.End()
+
+	wentOffline, err := presenceStore.Disconnect(ctx, userId)
+	if err != nil || !wentOffline {
+		return err
+	}
+
+	_, err = db.Client.ExecContext(ctx, `
+		INSERT INTO user_last_seen (user_id, last_seen_at)
+		VALUES ($1, Now())
+		ON CONFLICT (user_id) DO UPDATE SET last_seen_at = Now();
+	`, userId)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed saving last seen", logging.Err(err))
+	}
+
+	return publishPresence(ctx, userId)
+}
+
+func SetTyping(ctx context.Context, convId, userId string, isTyping bool) error {
+	ctx, span := tracing.Start(ctx, "services.SetTyping")
+	defer span.End()
+
+	participants, err := getParticipantIds(ctx, convId)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, id := range participants {
+		if id == userId {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrForbidden
+	}
+
+	if isTyping {
+		err = presenceStore.SetTyping(ctx, convId, userId, typingTimeout)
+	} else {
+		err = presenceStore.ClearTyping(ctx, convId, userId)
+	}
+	if err != nil {
+		return err
+	}
+
+	realtime.Publish(without(participants, userId), realtime.Event{
+		Type: "typing",
+		Data: typingEvent{ConversationId: convId, UserId: userId, IsTyping: isTyping},
+	})
+	return nil
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+const (
+	PermissionEveryone = "everyone"
+	PermissionAdmins   = "admins"
+	PermissionOwner    = "owner"
+)
+
+type ConversationPermissions struct {
+	AddUsers       string `json:"addUsers"`
+	EditInfo       string `json:"editInfo"`
+	PinMessages    string `json:"pinMessages"`
+	DeleteMessages string `json:"deleteMessages"`
+}
+
+func roleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleAdmin:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+func isPermissionLevel(level string) bool {
+	return level == PermissionEveryone || level == PermissionAdmins || level == PermissionOwner
+}
+
+// roleAllows reports whether a participant with the given role satisfies
+// the permission level.
+func roleAllows(role, level string) bool {
+	switch level {
+	case PermissionEveryone:
+		return roleRank(role) >= roleRank(RoleMember)
+	case PermissionAdmins:
+		return roleRank(role) >= roleRank(RoleAdmin)
+	case PermissionOwner:
+		return role == RoleOwner
+	}
+	return false
+}
+
+// getRole returns the role of an active participant or ErrForbidden when
+// userId is not one.
+func getRole(ctx context.Context, convId, userId string) (string, error) {
+	var role string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT role
+		FROM participants
+		WHERE conversation_id = $1 AND user_id = $2 AND has_left = false AND is_kicked = false;
+	`, convId, userId).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrForbidden
+	}
+	return role, err
+}
+
+func setRoleTx(ctx context.Context, tx *sql.Tx, convId, userId, role string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE participants SET role = $1, role_updated_at = Now()
+		WHERE conversation_id = $2 AND user_id = $3;
+	`, role, convId, userId)
+	return err
+}
+
+// transferOwnership makes newOwnerId the owner of the conversation and
+// demotes the previous owner to oldOwnerRole.
+func transferOwnership(ctx context.Context, tx *sql.Tx, convId, oldOwnerId, newOwnerId, oldOwnerRole string) error {
+	err := setRoleTx(ctx, tx, convId, oldOwnerId, oldOwnerRole)
+	if err != nil {
+		return err
+	}
+
+	err = setRoleTx(ctx, tx, convId, newOwnerId, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversations SET creator_id = $1
+		WHERE id = $2;
+	`, newOwnerId, convId)
+	if err != nil {
+		return err
+	}
+
+	_, err = createSystemMessage(ctx, tx, convId, oldOwnerId, systemMessage{
+		EventType: EventOwnerChanged,
+		UserIds:   []string{newOwnerId},
+	})
+	return err
+}
+
+func SetParticipantRole(ctx context.Context, convId, userId, targetId, role string) error {
+	ctx, span := tracing.Start(ctx, "services.SetParticipantRole")
+	defer span.End()
+
+	if role != RoleAdmin && role != RoleMember {
+		return ErrWrongData
+	}
+
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return err
+	}
+
+	if c.ConvType == "private" || c.CreatorId != userId || userId == targetId {
+		return ErrForbidden
+	}
+
+	targetRole, err := getRole(ctx, convId, targetId)
+	if err != nil {
+		return err
+	}
+
+	if targetRole == role {
+		return nil
+	}
+
+	_, err = db.Client.ExecContext(ctx, `
+		UPDATE participants SET role = $1, role_updated_at = Now()
+		WHERE conversation_id = $2 AND user_id = $3;
+	`, role, convId, targetId)
+	return err
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/jobs"
+	"github.com/yura4ka/crickter/logging"
+	"github.com/yura4ka/crickter/metrics"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	publishPostKind    = "posts.publish"
+	publishMessageKind = "messages.publish"
+)
+
+type publishPayload struct {
+	Id string `json:"id"`
+}
+
+// parseScheduledAt returns nil for content that should be published right
+// away and ErrWrongData when the time is malformed or not in the future.
+func parseScheduledAt(s *string) (*time.Time, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, *s)
+	if err != nil || !t.After(time.Now()) {
+		return nil, ErrWrongData
+	}
+
+	return &t, nil
+}
+
+func enqueuePublish(ctx context.Context, tx *sql.Tx, kind, id string, at time.Time) error {
+	return jobs.EnqueueTx(ctx, tx, kind, publishPayload{Id: id}, jobs.RunAt(at))
+}
+
+// isPublishRejected reports whether err means the content can no longer be
+// published, as opposed to a failure worth retrying.
+func isPublishRejected(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrForbidden) ||
+		errors.Is(err, ErrBlocked) || errors.Is(err, ErrCommentsDisabled)
+}
+
+func registerScheduledPublishing() {
+	jobs.Register(publishPostKind, func(ctx context.Context, p publishPayload) error {
+		return PublishScheduledPost(ctx, p.Id)
+	})
+	jobs.Register(publishMessageKind, func(ctx context.Context, p publishPayload) error {
+		return PublishScheduledMessage(ctx, p.Id)
+	})
+}
+
+// PublishScheduledPost publishes the post when it is due, checking again
+// that the posts it comments on or reposts still accept it. Jobs left over
+// from rescheduling or cancelling find nothing to do and return nil.
+func PublishScheduledPost(ctx context.Context, postId string) error {
+	ctx, span := tracing.Start(ctx, "services.PublishScheduledPost")
+	defer span.End()
+
+	var userId string
+	var commentToId, originalId *string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT user_id, comment_to_id, original_id
+		FROM posts
+		WHERE id = $1 AND scheduled_at <= Now() AND schedule_error IS NULL AND is_deleted = FALSE;
+	`, postId).Scan(&userId, &commentToId, &originalId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = checkPostTargets(ctx, userId, commentToId, originalId)
+	if isPublishRejected(err) {
+		return setScheduleError(ctx, "posts", postId, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Client.ExecContext(ctx, `
+		UPDATE posts SET scheduled_at = NULL, created_at = Now()
+		WHERE id = $1 AND scheduled_at IS NOT NULL;
+	`, postId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		metrics.PostsCreated.Inc()
+	}
+
+	return nil
+}
+
+// PublishScheduledMessage publishes the message when it is due, checking
+// again that the sender is still allowed to write to the conversation and
+// to share the post it carries.
+func PublishScheduledMessage(ctx context.Context, messageId string) error {
+	ctx, span := tracing.Start(ctx, "services.PublishScheduledMessage")
+	defer span.End()
+
+	var userId, convId string
+	var text, postId *string
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT user_id, conversation_id, text, post_id
+		FROM messages
+		WHERE id = $1 AND scheduled_at <= Now() AND schedule_error IS NULL AND is_deleted = 0;
+	`, messageId).Scan(&userId, &convId, &text, &postId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = checkCanSendContent(ctx, convId, userId, postId)
+	if isPublishRejected(err) {
+		return setScheduleError(ctx, "messages", messageId, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		UPDATE messages SET scheduled_at = NULL, created_at = Now(),
+			expires_at = (
+				SELECT Now() + make_interval(secs => c.message_ttl)
+				FROM conversations AS c
+				WHERE c.id = messages.conversation_id
+			)
+		WHERE id = $1 AND scheduled_at IS NOT NULL
+		RETURNING created_at;
+	`, messageId).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = markSentMessageRead(ctx, tx, convId, userId, messageId, createdAt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	metrics.MessagesSent.Inc()
+	publishNotification(ctx, messageEvent{
+		ConversationId: convId,
+		MessageId:      messageId,
+		UserId:         userId,
+		Text:           text,
+		CreatedAt:      createdAt,
+	})
+	return nil
+}
+
+// checkCanSendMessage makes sure userId is still an active participant,
+// has not been blocked in a private conversation and may post to a channel.
+func checkCanSendMessage(ctx context.Context, convId, userId string) error {
+	role, err := getRole(ctx, convId, userId)
+	if err != nil {
+		return err
+	}
+
+	c, err := getConversationById(ctx, convId)
+	if err != nil {
+		return err
+	}
+
+	switch c.ConvType {
+	case "private":
+		return checkPrivateBlocked(ctx, convId, userId)
+	case "channel":
+		if !roleAllows(role, PermissionAdmins) {
+			return ErrForbidden
+		}
+	}
+	return nil
+}
+
+// setScheduleError keeps content that failed to publish pending, so its
+// author can see why and either reschedule or cancel it.
+func setScheduleError(ctx context.Context, table, id string, reason error) error {
+	logging.FromContext(ctx).Info("scheduled content rejected",
+		slog.String("table", table), slog.String("id", id), slog.String("reason", reason.Error()))
+
+	_, err := db.Client.ExecContext(ctx, `
+		UPDATE `+table+` SET schedule_error = $1
+		WHERE id = $2;
+	`, reason.Error(), id)
+	return err
+}
+
+type ScheduledPost struct {
+	Id          string      `json:"id"`
+	CreatedAt   string      `json:"createdAt"`
+	ScheduledAt string      `json:"scheduledAt"`
+	Error       *string     `json:"error"`
+	Text        string      `json:"text"`
+	CanComment  bool        `json:"canComment"`
+	CommentToId *string     `json:"commentToId"`
+	ResponseTo  *string     `json:"responseToId"`
+	OriginalId  *string     `json:"originalId"`
+	Media       []PostMedia `json:"media" noscan:""`
+}
+
+type ScheduledMessage struct {
+	Id             string           `json:"id"`
+	CreatedAt      string           `json:"createdAt"`
+	ScheduledAt    string           `json:"scheduledAt"`
+	Error          *string          `json:"error"`
+	ConversationId string           `json:"conversationId"`
+	Text           *string          `json:"text"`
+	Media          MessageMediaList `json:"media"`
+	OriginalId     *string          `json:"originalId"`
+	ResponseToId   *string          `json:"responseToId"`
+	PostId         *string          `json:"postId"`
+}
+
+type ScheduledContent struct {
+	Posts    []ScheduledPost    `json:"posts"`
+	Messages []ScheduledMessage `json:"messages"`
+}
+
+func GetScheduled(ctx context.Context, userId string) (*ScheduledContent, error) {
+	ctx, span := tracing.Start(ctx, "services.GetScheduled")
+	defer span.End()
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT id, created_at, scheduled_at, schedule_error, text, can_comment,
+			comment_to_id, response_to_id, original_id
+		FROM posts
+		WHERE user_id = $1 AND scheduled_at IS NOT NULL AND is_deleted = FALSE
+		ORDER BY scheduled_at;
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := scanner.ScanRows(make([]ScheduledPost, 0), rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range posts {
+		media, err := GetPostMedia(ctx, posts[i].Id, false)
+		if err != nil {
+			return nil, err
+		}
+		posts[i].Media = make([]PostMedia, 0, len(media))
+		for _, m := range media {
+			posts[i].Media = append(posts[i].Media, m.PostMedia)
+		}
+	}
+
+	rows, err = db.Client.QueryContext(ctx, `
+		SELECT m.id, m.created_at, m.scheduled_at, m.schedule_error, m.conversation_id, m.text, mm.media,
+			m.original_id, m.response_to_id, m.post_id
+		FROM messages AS m
+		LEFT JOIN LATERAL (`+messageMediaQuery+`) mm ON TRUE
+		WHERE m.user_id = $1 AND m.scheduled_at IS NOT NULL AND m.is_deleted = 0
+		ORDER BY m.scheduled_at;
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := scanner.ScanRows(make([]ScheduledMessage, 0), rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScheduledContent{Posts: posts, Messages: messages}, nil
+}
+
+type RescheduleRequest struct {
+	ScheduledAt *string `json:"scheduledAt"`
+}
+
+func ReschedulePost(ctx context.Context, postId, userId string, params *RescheduleRequest) error {
+	ctx, span := tracing.Start(ctx, "services.ReschedulePost")
+	defer span.End()
+
+	return reschedule(ctx, "posts", publishPostKind, postId, userId, params)
+}
+
+func RescheduleMessage(ctx context.Context, messageId, userId string, params *RescheduleRequest) error {
+	ctx, span := tracing.Start(ctx, "services.RescheduleMessage")
+	defer span.End()
+
+	return reschedule(ctx, "messages", publishMessageKind, messageId, userId, params)
+}
+
+// reschedule moves pending content to a new time and clears the error of
+// a failed attempt. The job enqueued for the previous time becomes a no-op.
+func reschedule(ctx context.Context, table, kind, id, userId string, params *RescheduleRequest) error {
+	if params.ScheduledAt == nil {
+		return ErrWrongData
+	}
+
+	scheduledAt, err := parseScheduledAt(params.ScheduledAt)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE `+table+` SET scheduled_at = $1, schedule_error = NULL
+		WHERE id = $2 AND user_id = $3 AND scheduled_at IS NOT NULL;
+	`, scheduledAt, id, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrForbidden
+	}
+
+	err = enqueuePublish(ctx, tx, kind, id, *scheduledAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func CancelScheduledPost(ctx context.Context, postId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.CancelScheduledPost")
+	defer span.End()
+
+	return cancelScheduled(ctx, "posts", postId, userId)
+}
+
+func CancelScheduledMessage(ctx context.Context, messageId, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.CancelScheduledMessage")
+	defer span.End()
+
+	return cancelScheduled(ctx, "messages", messageId, userId)
+}
+
+// cancelScheduled deletes pending content outright since nobody but its
+// author has ever seen it. Messages leave a tombstone that only syncs to
+// the author.
+func cancelScheduled(ctx context.Context, table, id, userId string) error {
+	res, err := db.Client.ExecContext(ctx, `
+		DELETE FROM `+table+`
+		WHERE id = $1 AND user_id = $2 AND scheduled_at IS NOT NULL;
+	`, id, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrForbidden
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/scanner"
+	"github.com/yura4ka/crickter/tracing"
+)
+
+const (
+	// syncMaxAge is how old a token may be before the client gets a full
+	// snapshot instead of the changes.
+	syncMaxAge = 30 * 24 * time.Hour
+	// syncOverlap covers transactions that started before a token was
+	// issued but committed after it, so changes may be sent twice.
+	syncOverlap = time.Minute
+	// SYNC_SNAPSHOT_MESSAGES is the number of latest messages per
+	// conversation sent in a full snapshot.
+	SYNC_SNAPSHOT_MESSAGES = 50
+)
+
+// syncActiveParticipant is the SQL condition for conversations aliased as
+// o (participant row of the user) where the user is still a member.
+const syncActiveParticipant = "o.has_left = false AND o.is_kicked = false"
+
+type ReadState struct {
+	ConversationId    string  `json:"conversationId"`
+	UserId            string  `json:"userId"`
+	LastReadMessageId *string `json:"lastReadMessageId"`
+	LastReadAt        *string `json:"lastReadAt"`
+}
+
+type SyncResponse struct {
+	Token                string         `json:"token"`
+	Full                 bool           `json:"full"`
+	Conversations        []Conversation `json:"conversations"`
+	RemovedConversations []string       `json:"removedConversations"`
+	Messages             []Message      `json:"messages"`
+	DeletedMessages      []string       `json:"deletedMessages"`
+	ReadStates           []ReadState    `json:"readStates"`
+}
+
+func encodeSyncToken(t time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(t.UnixMicro(), 10)))
+}
+
+func decodeSyncToken(token string) (time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, ErrWrongData
+	}
+
+	micro, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return time.Time{}, ErrWrongData
+	}
+
+	return time.UnixMicro(micro), nil
+}
+
+// Sync returns everything that changed for userId since the token was
+// issued, or a full snapshot when there is no token or it is too old.
+func Sync(ctx context.Context, userId, token string) (*SyncResponse, error) {
+	ctx, span := tracing.Start(ctx, "services.Sync")
+	defer span.End()
+
+	var now time.Time
+	err := db.Client.QueryRowContext(ctx, "SELECT Now();").Scan(&now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResponse{
+		Token:                encodeSyncToken(now),
+		RemovedConversations: make([]string, 0),
+		DeletedMessages:      make([]string, 0),
+	}
+
+	if token == "" {
+		result.Full = true
+	} else {
+		since, err := decodeSyncToken(token)
+		if err != nil {
+			return nil, err
+		}
+		result.Full = now.Sub(since) > syncMaxAge
+
+		if !result.Full {
+			err = syncChanges(ctx, userId, since.Add(-syncOverlap), result)
+			return result, err
+		}
+	}
+
+	err = syncSnapshot(ctx, userId, result)
+	return result, err
+}
+
+func syncSnapshot(ctx context.Context, userId string, result *SyncResponse) error {
+	var err error
+
+	result.Conversations, err = getConversations(ctx, userId, syncActiveParticipant)
+	if err != nil {
+		return err
+	}
+
+	result.Messages, err = getMessages(ctx, userId, messageQuery{
+		where: `rp.has_left = false AND rp.is_kicked = false
+			AND m.id IN (
+				SELECT sm.id
+				FROM messages AS sm
+				WHERE sm.conversation_id = m.conversation_id
+				ORDER BY sm.created_at DESC
+				LIMIT $2
+			)`,
+		args: []any{SYNC_SNAPSHOT_MESSAGES},
+	})
+	if err != nil {
+		return err
+	}
+
+	result.ReadStates, err = getReadStates(ctx, userId, "TRUE")
+	return err
+}
+
+func syncChanges(ctx context.Context, userId string, since time.Time, result *SyncResponse) error {
+	var err error
+
+	result.Conversations, err = getConversations(ctx, userId, syncActiveParticipant+`
+		AND (c.updated_at > $2 OR o.updated_at > $2 OR lm.created_at > $2)`, since)
+	if err != nil {
+		return err
+	}
+
+	result.RemovedConversations, err = getRemovedConversations(ctx, userId, since)
+	if err != nil {
+		return err
+	}
+
+	result.Messages, err = getMessages(ctx, userId, messageQuery{
+		where: `rp.has_left = false AND rp.is_kicked = false
+			AND (m.created_at > $2 OR m.updated_at > $2)`,
+		args: []any{since},
+	})
+	if err != nil {
+		return err
+	}
+
+	result.DeletedMessages, err = getDeletedMessages(ctx, userId, since)
+	if err != nil {
+		return err
+	}
+
+	result.ReadStates, err = getReadStates(ctx, userId, "p.updated_at > $2", since)
+	return err
+}
+
+func queryIds(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := db.Client.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+
+	return result, rows.Err()
+}
+
+// getRemovedConversations returns the conversations userId left, was
+// kicked from or that were deleted since the given time.
+func getRemovedConversations(ctx context.Context, userId string, since time.Time) ([]string, error) {
+	return queryIds(ctx, `
+		SELECT o.conversation_id
+		FROM participants AS o
+		INNER JOIN conversations AS c ON o.conversation_id = c.id
+		WHERE o.user_id = $1 AND (
+			(o.has_left OR o.is_kicked) AND o.updated_at > $2
+			OR c.is_deleted = 1 AND c.updated_at > $2
+		);
+	`, userId, since)
+}
+
+// getDeletedMessages returns the messages that were deleted for userId or
+// expired since the given time. Deletions are taken from message_changes
+// since deleting for everyone does not bump updated_at, and messages that
+// are gone from the table from their deleted_messages tombstones.
+func getDeletedMessages(ctx context.Context, userId string, since time.Time) ([]string, error) {
+	return queryIds(ctx, `
+		SELECT m.id
+		FROM messages AS m
+		INNER JOIN participants AS rp ON m.conversation_id = rp.conversation_id AND rp.user_id = $1
+			AND rp.has_left = false AND rp.is_kicked = false
+		WHERE (m.is_deleted = 1 OR m.is_deleted = 2 AND m.user_id = $1)
+			AND EXISTS (
+				SELECT 1
+				FROM message_changes AS mc
+				WHERE mc.message_id = m.id AND mc.is_deleted != 0 AND mc.created_at > $2
+			)
+			OR (m.expires_at > $2 AND m.expires_at <= Now())
+		UNION
+		SELECT d.message_id
+		FROM deleted_messages AS d
+		INNER JOIN participants AS rp ON d.conversation_id = rp.conversation_id AND rp.user_id = $1
+			AND rp.has_left = false AND rp.is_kicked = false
+		WHERE d.deleted_at > $2 AND (d.user_id IS NULL OR d.user_id = $1);
+	`, userId, since)
+}
+
+// deleteOldTombstones removes the tombstones no sync token can still ask
+// for, since older tokens get a full snapshot.
+func deleteOldTombstones(ctx context.Context) (int64, error) {
+	res, err := db.Client.ExecContext(ctx, `
+		DELETE FROM deleted_messages
+		WHERE deleted_at < $1;
+	`, time.Now().Add(-syncMaxAge-syncOverlap))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// getReadStates returns the read watermarks of the participants of the
+// conversations userId is in, filtered by where on the participant row p.
+// Channel subscribers other than userId are left out.
+func getReadStates(ctx context.Context, userId, where string, args ...any) ([]ReadState, error) {
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT p.conversation_id, p.user_id, p.last_read_message_id, p.last_read_at
+		FROM participants AS p
+		INNER JOIN participants AS o ON p.conversation_id = o.conversation_id AND o.user_id = $1
+		INNER JOIN conversations AS c ON p.conversation_id = c.id
+		WHERE `+syncActiveParticipant+` AND p.last_read_at IS NOT NULL
+			AND (c.type != 'channel' OR p.role != 'member' OR p.user_id = $1)
+			AND `+where+`;
+	`, append([]any{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanner.ScanRows(make([]ReadState, 0), rows)
+}
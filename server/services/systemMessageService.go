@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/scanner"
+)
+
+const (
+	EventMessagePinned       = "message_pinned"
+	EventMessageUnpinned     = "message_unpinned"
+	EventUsersAdded          = "users_added"
+	EventUserKicked          = "user_kicked"
+	EventUserLeft            = "user_left"
+	EventUserJoined          = "user_joined"
+	EventConversationRenamed = "conversation_renamed"
+	EventOwnerChanged        = "owner_changed"
+	EventMessageTtlChanged   = "message_ttl_changed"
+)
+
+type systemMessage struct {
+	EventType string
+	UserIds   []string
+	MessageId *string
+	Text      *string
+}
+
+// createSystemMessage records an event in the conversation history on
+// behalf of actorId as part of tx.
+func createSystemMessage(ctx context.Context, tx *sql.Tx, convId, actorId string, m systemMessage) (string, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO messages (kind, user_id, conversation_id, event_type, event_user_ids, event_message_id, text)
+		VALUES ('system', $1, $2, $3, $4, $5, $6)
+		RETURNING id;
+	`, actorId, convId, m.EventType, pq.Array(m.UserIds), m.MessageId, m.Text).Scan(&id)
+	return id, err
+}
+
+// attachEventUsers loads the users referenced by system messages.
+func attachEventUsers(ctx context.Context, messages []Message) error {
+	ids := make([]string, 0)
+	for _, m := range messages {
+		if m.Event != nil {
+			ids = append(ids, m.Event.UserIds...)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.Client.QueryContext(ctx, `
+		SELECT id, id, username, name, avatar_url, avatar_type, is_deleted
+		FROM users
+		WHERE id = ANY($1);
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byId := make(map[string]MessageUser)
+	for rows.Next() {
+		var id string
+		var u MessageUser
+		if err := scanner.Scan(rows, &id, &u); err != nil {
+			return err
+		}
+		byId[id] = u
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		e := messages[i].Event
+		if e == nil {
+			continue
+		}
+		e.Users = make([]MessageUser, 0, len(e.UserIds))
+		for _, id := range e.UserIds {
+			if u, ok := byId[id]; ok {
+				e.Users = append(e.Users, u)
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,9 +1,11 @@
 package services
 
 import (
+	"context"
 	"time"
 
 	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/tracing"
 )
 
 const TAGS_PER_PAGE = 15
@@ -14,7 +16,10 @@ type TagsResponse struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
-func GetTags(page int) ([]TagsResponse, error) {
+func GetTags(ctx context.Context, page int) ([]TagsResponse, error) {
+	ctx, span := tracing.Start(ctx, "services.GetTags")
+	defer span.End()
+
 	limit := TAGS_PER_PAGE
 	offset := TAGS_PER_PAGE * (page - 1)
 
@@ -23,7 +28,7 @@ func GetTags(page int) ([]TagsResponse, error) {
 		offset = 0
 	}
 
-	rows, err := db.Client.Query(`
+	rows, err := db.Client.QueryContext(ctx, `
 		SELECT t.name, COUNT(pt.post_id) as count, t.created_at
 		FROM tags AS t
 		LEFT JOIN post_tags AS pt ON t.id = pt.tag_id
@@ -53,9 +58,12 @@ func GetTags(page int) ([]TagsResponse, error) {
 	return result, nil
 }
 
-func HasMoreTags(page int) (bool, error) {
+func HasMoreTags(ctx context.Context, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasMoreTags")
+	defer span.End()
+
 	var total int
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT COUNT(t.name)
 		FROM tags AS t
 		LEFT JOIN post_tags AS pt ON t.id = pt.tag_id
@@ -72,9 +80,12 @@ func HasMoreTags(page int) (bool, error) {
 	return total > page*TAGS_PER_PAGE, nil
 }
 
-func HasTagMorePosts(tag string, page int) (bool, error) {
+func HasTagMorePosts(ctx context.Context, tag string, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasTagMorePosts")
+	defer span.End()
+
 	var total int
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT count(pt.post_id)
 		FROM tags AS t
 		LEFT JOIN post_tags AS pt ON t.id = pt.tag_id
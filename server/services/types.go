@@ -1,5 +1,12 @@
 package services
 
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
 type UserAvatar struct {
 	Url  *string `json:"url"`
 	Type *string `json:"type"`
@@ -11,6 +18,8 @@ type MessageUser struct {
 	Name      *string     `json:"name,omitempty"`
 	Avatar    *UserAvatar `json:"avatar,omitempty"`
 	IsDeleted *bool       `json:"isDeleted"`
+	Presence  *Presence   `json:"presence,omitempty" noscan:""`
+	Role      *string     `json:"role,omitempty" noscan:""`
 }
 
 type MessageShort struct {
@@ -20,34 +29,65 @@ type MessageShort struct {
 	HasMedia       *bool        `json:"hasMedia"`
 	IsRepost       *bool        `json:"isRepost"`
 	IsPost         *bool        `json:"isPost"`
+	Kind           *string      `json:"kind"`
+	EventType      *string      `json:"eventType,omitempty"`
 	User           *MessageUser `json:"user,omitempty"`
 }
 
 type Conversation struct {
-	Id          string        `json:"id"`
-	ConvType    string        `json:"type"`
-	Name        *string       `json:"name,omitempty"`
-	User        *MessageUser  `json:"user,omitempty"`
-	UnreadCount int           `json:"unreadCount"`
-	LastMessage *MessageShort `json:"lastMessage,omitempty"`
+	Id           string        `json:"id"`
+	ConvType     string        `json:"type"`
+	Name         *string       `json:"name,omitempty"`
+	User         *MessageUser  `json:"user,omitempty"`
+	UnreadCount  int           `json:"unreadCount"`
+	MutedUntil   *string       `json:"mutedUntil"`
+	IsArchived   bool          `json:"isArchived"`
+	IsPinned     bool          `json:"isPinned"`
+	MarkedUnread bool          `json:"markedUnread"`
+	IsRequest    bool          `json:"isRequest"`
+	LastMessage  *MessageShort `json:"lastMessage,omitempty"`
 }
 
 type Message struct {
-	Id             string        `json:"id"`
-	CreatedAt      string        `json:"createdAt"`
-	UpdatedAt      *string       `json:"updatedAt,omitempty"`
-	Text           *string       `json:"text,omitempty"`
-	Media          *MessageMedia `json:"media,omitempty"`
-	IsDeleted      int           `json:"isDeleted"`
-	UserId         *string       `json:"userId,omitempty"`
-	ConversationId *string       `json:"conversationId,omitempty"`
-	OriginalId     *string       `json:"originalId,omitempty"`
-	ResponseToId   *string       `json:"responseToId,omitempty"`
-	PostId         *string       `json:"postId,omitempty"`
-	IsRead         bool          `json:"isRead"`
-	User           *MessageUser  `json:"user,omitempty"`
+	Id              string           `json:"id"`
+	CreatedAt       string           `json:"createdAt"`
+	UpdatedAt       *string          `json:"updatedAt,omitempty"`
+	ExpiresAt       *string          `json:"expiresAt,omitempty"`
+	ScheduledAt     *string          `json:"scheduledAt,omitempty"`
+	Text            *string          `json:"text,omitempty"`
+	Media           MessageMediaList `json:"media"`
+	IsDeleted       int              `json:"isDeleted"`
+	UserId          *string          `json:"userId,omitempty"`
+	ConversationId  *string          `json:"conversationId,omitempty"`
+	OriginalId      *string          `json:"originalId,omitempty"`
+	OriginalDeleted bool             `json:"originalDeleted,omitempty"`
+	ResponseToId    *string          `json:"responseToId,omitempty"`
+	PostId          *string          `json:"postId,omitempty"`
+	Kind            string           `json:"kind"`
+	Event           *MessageEvent    `json:"event,omitempty"`
+	IsRead          bool             `json:"isRead"`
+	Reactions       MessageReactions `json:"reactions"`
+	User            *MessageUser     `json:"user,omitempty"`
+	Poll            *Poll            `json:"poll,omitempty" noscan:""`
+}
+
+// MessageEvent describes what happened for system messages: who was
+// affected and which message it refers to.
+type MessageEvent struct {
+	Type      *string        `json:"type"`
+	UserIds   pq.StringArray `json:"userIds,omitempty"`
+	MessageId *string        `json:"messageId,omitempty"`
+	Users     []MessageUser  `json:"users,omitempty" noscan:""`
 }
 
+type MessageReaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+type MessageReactions []MessageReaction
+
 func (u *MessageUser) SqlClean() {
 	if *u.IsDeleted {
 		*u = MessageUser{IsDeleted: u.IsDeleted}
@@ -60,7 +100,7 @@ func (u *MessageUser) SqlClean() {
 }
 
 func (c *Conversation) SqlClean() {
-	if c.ConvType == "group" || c.User.IsDeleted == nil {
+	if c.ConvType != "private" || c.User.IsDeleted == nil {
 		c.User = nil
 	}
 
@@ -73,18 +113,43 @@ func (m *Message) SqlClean() {
 	m.UserId = nil
 	m.ConversationId = nil
 
-	if m.Media.Url == nil {
-		m.Media = nil
+	if m.Media == nil {
+		m.Media = MessageMediaList{}
+	}
+
+	if m.Event.Type == nil {
+		m.Event = nil
 	}
 
 	if m.CreatedAt == *m.UpdatedAt {
 		m.UpdatedAt = nil
 	}
 
+	if m.Reactions == nil {
+		m.Reactions = MessageReactions{}
+	}
+
 	if m.IsDeleted == 1 {
 		m.Text = nil
-		m.Media = nil
+		m.Media = MessageMediaList{}
 		m.User = nil
 		m.UpdatedAt = nil
+		m.Reactions = MessageReactions{}
+	}
+}
+
+func (r *MessageReactions) Scan(src any) error {
+	return scanJson(src, r)
+}
+
+func scanJson(src any, dest any) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
 	}
+	return errors.New("unsupported json source")
 }
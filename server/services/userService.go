@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/yura4ka/crickter/db"
+	"github.com/yura4ka/crickter/tracing"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -22,7 +24,10 @@ type NewUser struct {
 	Name     string `json:"name"`
 }
 
-func CreateUser(user *NewUser) (string, error) {
+func CreateUser(ctx context.Context, user *NewUser) (string, error) {
+	ctx, span := tracing.Start(ctx, "services.CreateUser")
+	defer span.End()
+
 	if len(user.Password) < 4 {
 		return "", ErrInvalidPassword
 	}
@@ -35,7 +40,7 @@ func CreateUser(user *NewUser) (string, error) {
 
 	var id string
 
-	err = db.Client.QueryRow(`
+	err = db.Client.QueryRowContext(ctx, `
 		INSERT INTO users (email, username, password, name)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id;
@@ -71,12 +76,17 @@ type User struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
-func GetUserByEmail(email string) (*User, error) {
+func GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "services.GetUserByEmail")
+	defer span.End()
+
 	var user User
 	var avatarUrl, avatarType *string
 
-	err := db.Client.QueryRow(`
-		SELECT * FROM users
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT id, created_at, email, password, name, username, is_private, updated_at,
+			avatar_url, bio, is_deleted, avatar_type
+		FROM users
 		WHERE email = $1;
 	`, email).Scan(&user.ID, &user.CreatedAt, &user.Email, &user.Password, &user.Name,
 		&user.Username, &user.IsPrivate, &user.UpdatedAt, &avatarUrl, &user.Bio, &user.IsDeleted, &avatarType)
@@ -92,12 +102,17 @@ func GetUserByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func GetUserById(id string) (*User, error) {
+func GetUserById(ctx context.Context, id string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "services.GetUserById")
+	defer span.End()
+
 	var user User
 	var avatarUrl, avatarType *string
 
-	err := db.Client.QueryRow(`
-		SELECT * FROM users
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT id, created_at, email, password, name, username, is_private, updated_at,
+			avatar_url, bio, is_deleted, avatar_type
+		FROM users
 		WHERE id = $1;
 	`, id).Scan(&user.ID, &user.CreatedAt, &user.Email, &user.Password, &user.Name,
 		&user.Username, &user.IsPrivate, &user.UpdatedAt, &avatarUrl, &user.Bio, &user.IsDeleted, &avatarType)
@@ -113,12 +128,17 @@ func GetUserById(id string) (*User, error) {
 	return &user, nil
 }
 
-func GetUserByUsername(username string) (*User, error) {
+func GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "services.GetUserByUsername")
+	defer span.End()
+
 	var user User
 	var avatarUrl, avatarType *string
 
-	err := db.Client.QueryRow(`
-		SELECT * FROM users
+	err := db.Client.QueryRowContext(ctx, `
+		SELECT id, created_at, email, password, name, username, is_private, updated_at,
+			avatar_url, bio, is_deleted, avatar_type
+		FROM users
 		WHERE username = $1;
 	`, username).Scan(&user.ID, &user.CreatedAt, &user.Email, &user.Password, &user.Name,
 		&user.Username, &user.IsPrivate, &user.UpdatedAt, &avatarUrl, &user.Bio, &user.IsDeleted, &avatarType)
@@ -143,11 +163,14 @@ type UserInfo struct {
 	Bio          *string `json:"bio"`
 }
 
-func GetUserInfo(id, requestUserId string) (*UserInfo, error) {
+func GetUserInfo(ctx context.Context, id, requestUserId string) (*UserInfo, error) {
+	ctx, span := tracing.Start(ctx, "services.GetUserInfo")
+	defer span.End()
+
 	var u UserInfo
 	var isDeleted bool
 	var avatarUrl, avatarType *string
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT u.id, u.created_at, u.name, u.username, u.is_private, u.avatar_url, u.avatar_type, u.bio, u.is_deleted,
 			COALESCE(COUNT(f1.*), 0) AS followers, COALESCE(f2.count, 0) AS following,
 			COALESCE(p.count, 0) AS post_count
@@ -182,7 +205,7 @@ func GetUserInfo(id, requestUserId string) (*UserInfo, error) {
 	}
 
 	if requestUserId != "" && id != requestUserId {
-		err := db.Client.QueryRow(`
+		err := db.Client.QueryRowContext(ctx, `
 			SELECT CASE WHEN COUNT(uf) != 0 THEN true ELSE false END is_subscribed
 			FROM users_followers uf
 			WHERE user_id = $1 AND follower_id = $2;
@@ -195,9 +218,12 @@ func GetUserInfo(id, requestUserId string) (*UserInfo, error) {
 	return &u, nil
 }
 
-func HasUserMorePosts(userId string, page int) (bool, error) {
+func HasUserMorePosts(ctx context.Context, userId string, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasUserMorePosts")
+	defer span.End()
+
 	var total int
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM posts WHERE user_id = $1 AND comment_to_id IS NULL AND is_deleted = FALSE;
 	`, userId).
 		Scan(&total)
@@ -207,16 +233,22 @@ func HasUserMorePosts(userId string, page int) (bool, error) {
 	return total > page*POSTS_PER_PAGE, nil
 }
 
-func HandleFollow(userId, followerId string) error {
-	_, err := db.Client.Exec(`
+func HandleFollow(ctx context.Context, userId, followerId string) error {
+	ctx, span := tracing.Start(ctx, "services.HandleFollow")
+	defer span.End()
+
+	_, err := db.Client.ExecContext(ctx, `
 		INSERT INTO users_followers (user_id, follower_id)
 		VALUES ($1, $2);
 	`, userId, followerId)
 	return err
 }
 
-func HandleUnFollow(userId, followerId string) error {
-	_, err := db.Client.Exec(`
+func HandleUnFollow(ctx context.Context, userId, followerId string) error {
+	ctx, span := tracing.Start(ctx, "services.HandleUnFollow")
+	defer span.End()
+
+	_, err := db.Client.ExecContext(ctx, `
 		DELETE FROM users_followers WHERE user_id = $1 AND follower_id = $2;
 	`, userId, followerId)
 	return err
@@ -227,12 +259,12 @@ type FollowInfo struct {
 	IsSubscribed bool `json:"isSubscribed"`
 }
 
-func getIsSubscribeMap(userId string) (map[string]bool, error) {
+func getIsSubscribeMap(ctx context.Context, userId string) (map[string]bool, error) {
 	isSubscribed := make(map[string]bool)
 	if userId == "" {
 		return isSubscribed, nil
 	}
-	requestFollowing, err := GetFollowing(userId, "", 0)
+	requestFollowing, err := GetFollowing(ctx, userId, "", 0)
 	if err != nil {
 		return nil, err
 	}
@@ -262,8 +294,11 @@ func parseFollowInfo(rows *sql.Rows, isSubscribed map[string]bool) ([]FollowInfo
 	return result, nil
 }
 
-func GetFollowing(userId, requestUserId string, page int) ([]FollowInfo, error) {
-	isSubscribed, err := getIsSubscribeMap(requestUserId)
+func GetFollowing(ctx context.Context, userId, requestUserId string, page int) ([]FollowInfo, error) {
+	ctx, span := tracing.Start(ctx, "services.GetFollowing")
+	defer span.End()
+
+	isSubscribed, err := getIsSubscribeMap(ctx, requestUserId)
 	if err != nil {
 		return nil, err
 	}
@@ -275,7 +310,7 @@ func GetFollowing(userId, requestUserId string, page int) ([]FollowInfo, error)
 		offset = USERS_PER_PAGE * (page - 1)
 	}
 
-	rows, err := db.Client.Query(`
+	rows, err := db.Client.QueryContext(ctx, `
 		SELECT u.id, u.name, u.username, u.created_at, u.is_private, u.avatar_url, u.avatar_type
 		FROM users_followers AS f
 		INNER JOIN users AS u ON f.user_id = u.id
@@ -293,13 +328,16 @@ func GetFollowing(userId, requestUserId string, page int) ([]FollowInfo, error)
 	return parseFollowInfo(rows, isSubscribed)
 }
 
-func GetFollowers(userId, requestUserId string, page int) ([]FollowInfo, error) {
-	isSubscribed, err := getIsSubscribeMap(requestUserId)
+func GetFollowers(ctx context.Context, userId, requestUserId string, page int) ([]FollowInfo, error) {
+	ctx, span := tracing.Start(ctx, "services.GetFollowers")
+	defer span.End()
+
+	isSubscribed, err := getIsSubscribeMap(ctx, requestUserId)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := db.Client.Query(`
+	rows, err := db.Client.QueryContext(ctx, `
 		SELECT u.id, u.name, u.username, u.created_at, u.is_private, u.avatar_url, u.avatar_type
 		FROM users_followers AS f
 		INNER JOIN users AS u ON f.follower_id = u.id
@@ -317,9 +355,12 @@ func GetFollowers(userId, requestUserId string, page int) ([]FollowInfo, error)
 	return parseFollowInfo(rows, isSubscribed)
 }
 
-func HasMoreFollowing(userId string, page int) (bool, error) {
+func HasMoreFollowing(ctx context.Context, userId string, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasMoreFollowing")
+	defer span.End()
+
 	var count int
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT COUNT(u.*) 
 		FROM users_followers AS f
 		INNER JOIN users AS u ON f.follower_id = u.id
@@ -331,9 +372,12 @@ func HasMoreFollowing(userId string, page int) (bool, error) {
 	return count > page*USERS_PER_PAGE, nil
 }
 
-func HasMoreFollowers(userId string, page int) (bool, error) {
+func HasMoreFollowers(ctx context.Context, userId string, page int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.HasMoreFollowers")
+	defer span.End()
+
 	var count int
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT COUNT(u.*) 
 		FROM users_followers AS f
 		INNER JOIN users AS u ON f.user_id = u.id
@@ -352,9 +396,14 @@ type ChangeUserRequest struct {
 	Bio             *string `json:"bio"`
 	Password        *string `json:"password"`
 	ConfirmPassword *string `json:"confirmPassword"`
+	HideLastSeen    *bool   `json:"hideLastSeen"`
+	MessagePrivacy  *string `json:"messagePrivacy"`
 }
 
-func ChangeUser(userId string, user *ChangeUserRequest) error {
+func ChangeUser(ctx context.Context, userId string, user *ChangeUserRequest) error {
+	ctx, span := tracing.Start(ctx, "services.ChangeUser")
+	defer span.End()
+
 	var oldPassword string
 	var oldAvatar *string
 
@@ -363,7 +412,7 @@ func ChangeUser(userId string, user *ChangeUserRequest) error {
 	}
 
 	if user.Password != nil || user.Avatar != nil {
-		err := db.Client.QueryRow(`
+		err := db.Client.QueryRowContext(ctx, `
 			SELECT password, avatar_url FROM users WHERE id = $1;
 		`, userId).Scan(&oldPassword, &oldAvatar)
 
@@ -413,6 +462,21 @@ func ChangeUser(userId string, user *ChangeUserRequest) error {
 		argsCount++
 	}
 
+	if user.HideLastSeen != nil {
+		queries = append(queries, fmt.Sprintf("hide_last_seen = $%d", argsCount))
+		args = append(args, *user.HideLastSeen)
+		argsCount++
+	}
+
+	if user.MessagePrivacy != nil {
+		if !isValidMessagePrivacy(*user.MessagePrivacy) {
+			return ErrWrongData
+		}
+		queries = append(queries, fmt.Sprintf("message_privacy = $%d", argsCount))
+		args = append(args, *user.MessagePrivacy)
+		argsCount++
+	}
+
 	if user.Avatar != nil {
 		if user.Avatar.Url == "" {
 			user.Avatar.Type = user.Avatar.Url
@@ -424,7 +488,7 @@ func ChangeUser(userId string, user *ChangeUserRequest) error {
 
 	args = append(args, userId)
 
-	_, err := db.Client.Exec(
+	_, err := db.Client.ExecContext(ctx,
 		"UPDATE USERS SET\n"+strings.Join(queries, ", ")+fmt.Sprintf("\nWHERE id = $%d", argsCount),
 		args...,
 	)
@@ -432,13 +496,19 @@ func ChangeUser(userId string, user *ChangeUserRequest) error {
 	return err
 }
 
-func DeleteUser(userId string) error {
-	_, err := db.Client.Exec("CALL delete_user($1);", userId)
+func DeleteUser(ctx context.Context, userId string) error {
+	ctx, span := tracing.Start(ctx, "services.DeleteUser")
+	defer span.End()
+
+	_, err := db.Client.ExecContext(ctx, "CALL delete_user($1);", userId)
 	return err
 }
 
-func BlockUser(userId, blockUserId string) error {
-	_, err := db.Client.Exec(`
+func BlockUser(ctx context.Context, userId, blockUserId string) error {
+	ctx, span := tracing.Start(ctx, "services.BlockUser")
+	defer span.End()
+
+	_, err := db.Client.ExecContext(ctx, `
 		INSERT INTO blocked_users (user_id, blocked_user_id)
 		VALUES ($1, $2)
 		ON CONFLICT DO NOTHING;
@@ -446,17 +516,23 @@ func BlockUser(userId, blockUserId string) error {
 	return err
 }
 
-func UnblockUser(userId, blockUserId string) error {
-	_, err := db.Client.Exec(`
+func UnblockUser(ctx context.Context, userId, blockUserId string) error {
+	ctx, span := tracing.Start(ctx, "services.UnblockUser")
+	defer span.End()
+
+	_, err := db.Client.ExecContext(ctx, `
 		DELETE FROM blocked_users
 		WHERE user_id = $1 AND blocked_user_id = $2;
 	`, userId, blockUserId)
 	return err
 }
 
-func IsUserBlocked(userId, blockedUserId string) (bool, error) {
+func IsUserBlocked(ctx context.Context, userId, blockedUserId string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "services.IsUserBlocked")
+	defer span.End()
+
 	isBlocked := false
-	err := db.Client.QueryRow(`
+	err := db.Client.QueryRowContext(ctx, `
 		SELECT true
 		FROM blocked_users
 		WHERE user_id = $1 AND blocked_user_id = $2;
@@ -471,9 +547,9 @@ func IsUserBlocked(userId, blockedUserId string) (bool, error) {
 	return isBlocked, nil
 }
 
-func getBlockedMap(blockedUser string) (map[string]bool, error) {
+func getBlockedMap(ctx context.Context, blockedUser string) (map[string]bool, error) {
 	blocked := make(map[string]bool)
-	rows, err := db.Client.Query(`
+	rows, err := db.Client.QueryContext(ctx, `
 		SELECT user_id
 		FROM blocked_users
 		WHERE blocked_user_id = $1;
@@ -1,6 +1,10 @@
 package services
 
-import "database/sql"
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+)
 
 func ToNullString(s *string) sql.NullString {
 	if s == nil || len(*s) == 0 {
@@ -11,3 +15,12 @@ func ToNullString(s *string) sql.NullString {
 		Valid:  true,
 	}
 }
+
+// newRandomToken returns n random bytes encoded to be safe in URLs.
+func newRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}